@@ -1,11 +1,13 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"net/http"
+	"strconv"
 
+	"github.com/germandv/ama/internal/protocol"
 	"github.com/germandv/ama/internal/questionnaire"
+	"github.com/germandv/ama/internal/voter"
 	"github.com/germandv/ama/internal/webutils"
 	"github.com/germandv/ama/internal/wsmanager"
 )
@@ -13,7 +15,8 @@ import (
 func newQuestionnaireHandler(svc questionnaire.IService, web webutils.Web) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		type Req struct {
-			Title string `json:"title"`
+			Title    string                 `json:"title"`
+			Settings questionnaire.Settings `json:"settings"`
 		}
 
 		req := &Req{}
@@ -22,7 +25,7 @@ func newQuestionnaireHandler(svc questionnaire.IService, web webutils.Web) http.
 			return
 		}
 
-		q, err := svc.Create(req.Title)
+		q, err := svc.Create(req.Title, req.Settings, clientIP(r))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
@@ -36,11 +39,16 @@ func newQuestionnaireHandler(svc questionnaire.IService, web webutils.Web) http.
 func newQuestionHandler(
 	svc questionnaire.IService,
 	wsm *wsmanager.WSManager,
+	voters *voter.Issuer,
 	web webutils.Web,
 ) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		type Req struct {
 			Question string `json:"question"`
+			// Force asks the question even if similar ones were already asked.
+			Force    bool   `json:"force"`
+			Tag      string `json:"tag"`
+			Panelist string `json:"panelist"`
 		}
 
 		req := &Req{}
@@ -49,31 +57,77 @@ func newQuestionHandler(
 			return
 		}
 
-		questionnaire := r.PathValue("id")
-		if questionnaire == "" {
+		questionnaireID := r.PathValue("id")
+		if questionnaireID == "" {
 			http.Error(w, "no questionnaire ID provided", http.StatusBadRequest)
 			return
 		}
 
-		q, err := svc.Ask(questionnaire, req.Question)
+		q, err := svc.Ask(questionnaireID, questionnaire.AskRequest{
+			Text:     req.Question,
+			Author:   participant(r, voters),
+			Force:    req.Force,
+			Tag:      req.Tag,
+			Panelist: req.Panelist,
+		})
 		if err != nil {
+			var slowModeErr *questionnaire.SlowModeError
+			if errors.As(err, &slowModeErr) {
+				if slowModeErr.RetryAfter > 0 {
+					w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(slowModeErr.RetryAfter)))
+				}
+				web.TooManyRequests(w, slowModeErr.Error())
+				return
+			}
+			if errors.Is(err, questionnaire.ErrBanned) {
+				web.SendError(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			var rejection *questionnaire.RejectionError
+			if errors.As(err, &rejection) {
+				web.JSON(w, http.StatusUnprocessableEntity, rejection)
+				return
+			}
+			var duplicate *questionnaire.DuplicateError
+			if errors.As(err, &duplicate) {
+				web.JSON(w, http.StatusConflict, map[string]any{
+					"reason":  "duplicate",
+					"message": duplicate.Error(),
+					"matches": duplicate.Matches,
+				})
+				return
+			}
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		msg := newQuestionMessage(q.ID, q.Question, q.Metadata.Votes)
-		jsonMsg, err := json.Marshal(msg)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		// Questions of shadow banned participants are not broadcast,
+		// their author gets it from the response only.
+		if !q.Shadowed {
+			msg := protocol.NewQuestion(q.ID, q.Question, q.Metadata.Votes, q.Tag, q.Panelist)
+			jsonMsg, err := protocol.Encode(msg)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			wsm.Broadcast(questionnaireID, jsonMsg)
+
+			if len(q.PossibleDuplicates) > 0 {
+				msg := protocol.NewDuplicateFlag(q.ID, q.PossibleDuplicates)
+				jsonMsg, err := protocol.Encode(msg)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				wsm.BroadcastHosts(questionnaireID, jsonMsg)
+			}
 		}
-		wsm.Broadcast(questionnaire, jsonMsg)
 
 		web.JSON(w, http.StatusCreated, q)
 	}
 }
 
-func getQuestionsHandler(svc questionnaire.IService, web webutils.Web) http.HandlerFunc {
+func getQuestionsHandler(svc questionnaire.IService, voters *voter.Issuer, web webutils.Web) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		questionnaireID := r.PathValue("id")
 		if questionnaireID == "" {
@@ -81,7 +135,7 @@ func getQuestionsHandler(svc questionnaire.IService, web webutils.Web) http.Hand
 			return
 		}
 
-		qs, err := svc.Get(questionnaireID)
+		qs, err := svc.Get(questionnaireID, participant(r, voters))
 		if err != nil {
 			web.NotFound(w, "questionnaire", questionnaireID)
 			return
@@ -90,7 +144,56 @@ func getQuestionsHandler(svc questionnaire.IService, web webutils.Web) http.Hand
 		envelope := struct {
 			Questions []questionnaire.Question `json:"questions"`
 		}{
-			Questions: qs,
+			Questions: questionnaire.ForPanelist(
+				questionnaire.ByTag(qs, r.URL.Query().Get("tag")),
+				r.URL.Query().Get("panelist"),
+			),
+		}
+		web.JSON(w, http.StatusOK, envelope)
+	}
+}
+
+func suggestHandler(svc questionnaire.IService, voters *voter.Issuer, web webutils.Web) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		questionnaireID := r.PathValue("id")
+		if questionnaireID == "" {
+			web.BadRequest(w, errors.New("no questionnaire ID provided"))
+			return
+		}
+
+		matches, err := svc.Suggest(questionnaireID, r.URL.Query().Get("q"), participant(r, voters))
+		if err != nil {
+			web.NotFound(w, "questionnaire", questionnaireID)
+			return
+		}
+
+		envelope := struct {
+			Matches []questionnaire.Match `json:"matches"`
+		}{
+			Matches: matches,
+		}
+		web.JSON(w, http.StatusOK, envelope)
+	}
+}
+
+func searchHandler(svc questionnaire.IService, voters *voter.Issuer, web webutils.Web) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		questionnaireID := r.PathValue("id")
+		if questionnaireID == "" {
+			web.BadRequest(w, errors.New("no questionnaire ID provided"))
+			return
+		}
+
+		results, err := svc.Search(questionnaireID, r.URL.Query().Get("q"), participant(r, voters))
+		if err != nil {
+			web.NotFound(w, "questionnaire", questionnaireID)
+			return
+		}
+
+		envelope := struct {
+			Results []questionnaire.SearchResult `json:"results"`
+		}{
+			Results: results,
 		}
 		web.JSON(w, http.StatusOK, envelope)
 	}
@@ -98,7 +201,8 @@ func getQuestionsHandler(svc questionnaire.IService, web webutils.Web) http.Hand
 
 func voteHandler(
 	svc questionnaire.IService,
-	wsm *wsmanager.WSManager,
+	voters *voter.Issuer,
+	votes *voteCoalescer,
 	web webutils.Web,
 ) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -114,31 +218,79 @@ func voteHandler(
 			return
 		}
 
-		cookie, err := r.Cookie("voter")
+		_, err := r.Cookie("voter")
 		if err != nil {
 			web.Forbidden(w)
 			return
 		}
 
-		count, err := svc.Vote(questionnaireID, questionID, cookie.Value)
+		count, err := svc.Vote(questionnaireID, questionID, participant(r, voters))
+		if errors.Is(err, questionnaire.ErrBanned) {
+			web.SendError(w, err.Error(), http.StatusForbidden)
+			return
+		}
 		if err != nil {
 			web.BadRequest(w, err)
 			return
 		}
 
-		msg := newVoteMessage(questionID, count)
-		jsonMsg, err := json.Marshal(msg)
+		votes.Add(questionnaireID, questionID, count)
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func answerHandler(
+	svc questionnaire.IService,
+	wsm *wsmanager.WSManager,
+	web webutils.Web,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		questionnaireID := r.PathValue("id")
+		if questionnaireID == "" {
+			web.BadRequest(w, errors.New("no questionnaire ID provided"))
+			return
+		}
+
+		questionID := r.PathValue("question_id")
+		if questionID == "" {
+			web.BadRequest(w, errors.New("no question ID provided"))
+			return
+		}
+
+		_, ok := requireHost(svc, web, w, r, questionnaireID)
+		if !ok {
+			return
+		}
+
+		unspotlighted, err := svc.Answer(questionnaireID, questionID)
 		if err != nil {
 			web.InternalError(w, err)
 			return
 		}
+
+		msg := protocol.NewAnswer(questionID)
+		jsonMsg, err := protocol.Encode(msg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 		wsm.Broadcast(questionnaireID, jsonMsg)
 
+		if unspotlighted {
+			jsonMsg, err = protocol.Encode(protocol.NewSpotlight("", ""))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			wsm.Broadcast(questionnaireID, jsonMsg)
+		}
+
 		w.WriteHeader(http.StatusOK)
 	}
 }
 
-func answerHandler(
+func tagHandler(
 	svc questionnaire.IService,
 	wsm *wsmanager.WSManager,
 	web webutils.Web,
@@ -156,26 +308,122 @@ func answerHandler(
 			return
 		}
 
-		meta, err := svc.GetMeta(questionnaireID)
+		_, ok := requireHost(svc, web, w, r, questionnaireID)
+		if !ok {
+			return
+		}
+
+		type Req struct {
+			// Tag is empty to untag the question.
+			Tag string `json:"tag"`
+		}
+
+		req := &Req{}
+		ok = web.DecodeBody(w, r, req)
+		if !ok {
+			return
+		}
+
+		err := svc.Tag(questionnaireID, questionID, req.Tag)
 		if err != nil {
-			web.NotFound(w, "questionnaire", questionnaireID)
+			web.BadRequest(w, err)
 			return
 		}
 
-		cookie, err := r.Cookie("host")
-		if err != nil || cookie.Value != meta.Host {
-			web.Forbidden(w)
+		msg := protocol.NewTag(questionID, req.Tag)
+		jsonMsg, err := protocol.Encode(msg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		wsm.Broadcast(questionnaireID, jsonMsg)
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
 
-		err = svc.Answer(questionnaireID, questionID)
+func pinHandler(
+	svc questionnaire.IService,
+	wsm *wsmanager.WSManager,
+	web webutils.Web,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		questionnaireID := r.PathValue("id")
+		if questionnaireID == "" {
+			web.BadRequest(w, errors.New("no questionnaire ID provided"))
+			return
+		}
+
+		_, ok := requireHost(svc, web, w, r, questionnaireID)
+		if !ok {
+			return
+		}
+
+		type Req struct {
+			// QuestionID is empty to unpin.
+			QuestionID string `json:"question_id"`
+		}
+
+		req := &Req{}
+		ok = web.DecodeBody(w, r, req)
+		if !ok {
+			return
+		}
+
+		err := svc.Pin(questionnaireID, req.QuestionID)
 		if err != nil {
-			web.InternalError(w, err)
+			web.BadRequest(w, err)
+			return
+		}
+
+		msg := protocol.NewPin(req.QuestionID)
+		jsonMsg, err := protocol.Encode(msg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		wsm.Broadcast(questionnaireID, jsonMsg)
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func spotlightHandler(
+	svc questionnaire.IService,
+	wsm *wsmanager.WSManager,
+	web webutils.Web,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		questionnaireID := r.PathValue("id")
+		if questionnaireID == "" {
+			web.BadRequest(w, errors.New("no questionnaire ID provided"))
 			return
 		}
 
-		msg := newAnswerMessage(questionID)
-		jsonMsg, err := json.Marshal(msg)
+		_, ok := requireHost(svc, web, w, r, questionnaireID)
+		if !ok {
+			return
+		}
+
+		type Req struct {
+			// QuestionID is empty to clear the spotlight.
+			QuestionID string `json:"question_id"`
+		}
+
+		req := &Req{}
+		ok = web.DecodeBody(w, r, req)
+		if !ok {
+			return
+		}
+
+		q, err := svc.Spotlight(questionnaireID, req.QuestionID)
+		if err != nil {
+			web.BadRequest(w, err)
+			return
+		}
+
+		msg := protocol.NewSpotlight(q.ID, q.Question)
+		jsonMsg, err := protocol.Encode(msg)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -185,3 +433,167 @@ func answerHandler(
 		w.WriteHeader(http.StatusOK)
 	}
 }
+
+func banHandler(svc questionnaire.IService, web webutils.Web) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		questionnaireID := r.PathValue("id")
+		if questionnaireID == "" {
+			web.BadRequest(w, errors.New("no questionnaire ID provided"))
+			return
+		}
+
+		questionID := r.PathValue("question_id")
+		if questionID == "" {
+			web.BadRequest(w, errors.New("no question ID provided"))
+			return
+		}
+
+		_, ok := requireHost(svc, web, w, r, questionnaireID)
+		if !ok {
+			return
+		}
+
+		type Req struct {
+			Shadow bool `json:"shadow"`
+		}
+
+		req := &Req{}
+		ok = web.DecodeBody(w, r, req)
+		if !ok {
+			return
+		}
+
+		err := svc.Ban(questionnaireID, questionID, req.Shadow)
+		if err != nil {
+			web.BadRequest(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func mergeHandler(
+	svc questionnaire.IService,
+	wsm *wsmanager.WSManager,
+	web webutils.Web,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		questionnaireID := r.PathValue("id")
+		if questionnaireID == "" {
+			web.BadRequest(w, errors.New("no questionnaire ID provided"))
+			return
+		}
+
+		questionID := r.PathValue("question_id")
+		if questionID == "" {
+			web.BadRequest(w, errors.New("no question ID provided"))
+			return
+		}
+
+		_, ok := requireHost(svc, web, w, r, questionnaireID)
+		if !ok {
+			return
+		}
+
+		type Req struct {
+			// Questions are the IDs of the questions to merge into the one in the path.
+			Questions []string `json:"questions"`
+		}
+
+		req := &Req{}
+		ok = web.DecodeBody(w, r, req)
+		if !ok {
+			return
+		}
+
+		res, err := svc.Merge(questionnaireID, questionID, req.Questions)
+		if err != nil {
+			web.BadRequest(w, err)
+			return
+		}
+		q := res.Question
+
+		msgs := []protocol.Message{protocol.NewMerged(q.ID, q.Metadata.Votes, req.Questions)}
+		if res.Unpinned {
+			msgs = append(msgs, protocol.NewPin(""))
+		}
+		if res.Unspotlighted {
+			msgs = append(msgs, protocol.NewSpotlight("", ""))
+		}
+		for _, msg := range msgs {
+			jsonMsg, err := protocol.Encode(msg)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			wsm.Broadcast(questionnaireID, jsonMsg)
+		}
+
+		web.JSON(w, http.StatusOK, q)
+	}
+}
+
+func settingsHandler(svc questionnaire.IService, web webutils.Web) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		questionnaireID := r.PathValue("id")
+		if questionnaireID == "" {
+			web.BadRequest(w, errors.New("no questionnaire ID provided"))
+			return
+		}
+
+		_, ok := requireHost(svc, web, w, r, questionnaireID)
+		if !ok {
+			return
+		}
+
+		patch := &questionnaire.SettingsPatch{}
+		ok = web.DecodeBody(w, r, patch)
+		if !ok {
+			return
+		}
+
+		q, err := svc.UpdateSettings(questionnaireID, *patch)
+		if err != nil {
+			web.BadRequest(w, err)
+			return
+		}
+
+		web.JSON(w, http.StatusOK, q.Settings)
+	}
+}
+
+// requireHost checks that the request comes from the host of the questionnaire.
+// If it does not, it replies with an error and returns false.
+func requireHost(
+	svc questionnaire.IService,
+	web webutils.Web,
+	w http.ResponseWriter,
+	r *http.Request,
+	questionnaireID string,
+) (questionnaire.Questionnaire, bool) {
+	meta, err := svc.GetMeta(questionnaireID)
+	if err != nil {
+		web.NotFound(w, "questionnaire", questionnaireID)
+		return questionnaire.Questionnaire{}, false
+	}
+
+	cookie, err := r.Cookie("host")
+	if err != nil || cookie.Value != meta.Host {
+		web.Forbidden(w)
+		return questionnaire.Questionnaire{}, false
+	}
+
+	return meta, true
+}
+
+// participant identifies the client making the request.
+func participant(r *http.Request, voters *voter.Issuer) questionnaire.Participant {
+	p := questionnaire.Participant{IP: clientIP(r)}
+	cookie, err := r.Cookie("voter")
+	if err == nil {
+		p.Voter = cookie.Value
+		_, p.Verified = voters.IssuedAt(cookie.Value)
+	}
+	return p
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/germandv/ama/internal/pow"
+	"github.com/germandv/ama/internal/questionnaire"
+	"github.com/germandv/ama/internal/webutils"
+)
+
+// proofOfWorkHeader carries the solution to a challenge, as "<nonce>:<counter>".
+var proofOfWorkHeader = http.CanonicalHeaderKey("X-Proof-Of-Work")
+
+func challengeHandler(svc questionnaire.IService, challenger *pow.Challenger, web webutils.Web) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		questionnaireID := r.PathValue("id")
+		if questionnaireID == "" {
+			web.BadRequest(w, errors.New("no questionnaire ID provided"))
+			return
+		}
+
+		_, err := svc.GetMeta(questionnaireID)
+		if err != nil {
+			web.NotFound(w, "questionnaire", questionnaireID)
+			return
+		}
+
+		web.JSON(w, http.StatusOK, challenger.Issue(questionnaireID))
+	}
+}
+
+// botProtection requires a solved proof-of-work challenge
+// on questionnaires that have bot protection enabled.
+func botProtection(
+	svc questionnaire.IService,
+	challenger *pow.Challenger,
+	web webutils.Web,
+) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			questionnaireID := r.PathValue("id")
+			if questionnaireID == "" {
+				web.BadRequest(w, errors.New("no questionnaire ID provided"))
+				return
+			}
+
+			meta, err := svc.GetMeta(questionnaireID)
+			if err != nil {
+				web.NotFound(w, "questionnaire", questionnaireID)
+				return
+			}
+
+			if meta.Settings.BotProtection {
+				err = challenger.Verify(questionnaireID, r.Header.Get(proofOfWorkHeader))
+				if err != nil {
+					web.SendError(w, err.Error(), http.StatusForbidden)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/germandv/ama/internal/protocol"
+	"github.com/germandv/ama/internal/wsmanager"
+)
+
+// voteCoalescer batches vote count updates per room and broadcasts them as a
+// single "votes" message every interval, instead of one message per vote.
+type voteCoalescer struct {
+	mu       sync.Mutex
+	interval time.Duration
+	pending  map[string]map[string]uint16
+	wsm      *wsmanager.WSManager
+	logger   *slog.Logger
+}
+
+func newVoteCoalescer(interval time.Duration, wsm *wsmanager.WSManager, logger *slog.Logger) *voteCoalescer {
+	return &voteCoalescer{
+		interval: interval,
+		pending:  make(map[string]map[string]uint16),
+		wsm:      wsm,
+		logger:   logger,
+	}
+}
+
+// Add records the latest vote count of a question.
+// The first update for a room schedules a flush for that room.
+func (vc *voteCoalescer) Add(room string, questionID string, votes uint16) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	counts, found := vc.pending[room]
+	if !found {
+		counts = make(map[string]uint16)
+		vc.pending[room] = counts
+		time.AfterFunc(vc.interval, func() { vc.flush(room) })
+	}
+
+	// Votes only go up, keep the highest count in case updates arrive out of order.
+	if votes > counts[questionID] {
+		counts[questionID] = votes
+	}
+}
+
+func (vc *voteCoalescer) flush(room string) {
+	vc.mu.Lock()
+	counts := vc.pending[room]
+	delete(vc.pending, room)
+	vc.mu.Unlock()
+
+	if len(counts) == 0 {
+		return
+	}
+
+	jsonMsg, err := protocol.Encode(protocol.NewVotes(counts))
+	if err != nil {
+		vc.logger.Error("error encoding votes message", "err", err)
+		return
+	}
+	vc.wsm.Broadcast(room, jsonMsg)
+}
+
+// Flush broadcasts pending vote counts of every room right away.
+func (vc *voteCoalescer) Flush() {
+	vc.mu.Lock()
+	rooms := make([]string, 0, len(vc.pending))
+	for room := range vc.pending {
+		rooms = append(rooms, room)
+	}
+	vc.mu.Unlock()
+
+	for _, room := range rooms {
+		vc.flush(room)
+	}
+}
@@ -4,10 +4,15 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/germandv/ama/internal/questionnaire"
+	"github.com/germandv/ama/internal/ratelimit"
+	"github.com/germandv/ama/internal/uid"
 	"github.com/joho/godotenv"
 )
 
@@ -20,6 +25,77 @@ type AppConfig struct {
 	RedisPort int
 	RedisPass string
 	LogLevel  slog.Level
+
+	// VoteFlushInterval is how often batched vote counts are broadcast to each room.
+	VoteFlushInterval time.Duration
+
+	// WSMaxConnsPerIP caps concurrent WebSocket connections from a single IP.
+	// Audiences often share an IP (a venue's Wi-Fi, a corporate NAT), so the
+	// default is generous; it is meant to stop a single client opening thousands.
+	WSMaxConnsPerIP int
+
+	// Per client rates for creating questionnaires, asking questions and voting.
+	QuestionnaireRate ratelimit.Rate
+	QuestionRate      ratelimit.Rate
+	VoteRate          ratelimit.Rate
+	// QRRate is the per client rate for rendering QR codes, which is CPU heavy.
+	QRRate ratelimit.Rate
+	// RateLimitByVoter identifies clients asking and voting by their voter cookie instead of their IP.
+	RateLimitByVoter bool
+	// RateLimitBackend is where rate limiting state is kept, "memory" or "redis".
+	// Deployments with more than one replica need "redis".
+	RateLimitBackend string
+
+	Limits Limits
+
+	// TrustedProxies are the networks of the proxies allowed to tell the client IP
+	// with forwarding headers. Without them, the socket address is used, so they must
+	// be set when running behind a proxy or load balancer: otherwise every client looks
+	// like the proxy, and all of them share the per-IP limits.
+	TrustedProxies []*net.IPNet
+	// TrustedProxyHeader is the header the trusted proxies set, the only one read.
+	TrustedProxyHeader string
+
+	// PowSecret signs proof-of-work challenges, replicas must share it.
+	PowSecret []byte
+	// PowDifficulty is the number of leading zero bits required in solutions.
+	PowDifficulty int
+	// PowTTL is how long clients have to solve a challenge.
+	PowTTL time.Duration
+
+	// VoterSecret signs voter cookies, replicas must share it.
+	VoterSecret []byte
+	// GeneratedSecrets are the env vars of the secrets that were not set, so a random one
+	// is used instead. It is lost on restart and differs across replicas.
+	GeneratedSecrets []string
+
+	// Anomaly holds the thresholds of vote anomaly detection.
+	Anomaly questionnaire.AnalyzerConfig
+
+	// Blocklist are the words filtered out of every question.
+	Blocklist []string
+}
+
+// Limits are the operator-set caps on resources and time.
+type Limits struct {
+	// MaxQuestionnaires is the number of live questionnaires the server holds.
+	MaxQuestionnaires int
+	// MaxQuestionnairesPerClient is the number of live questionnaires each client can create.
+	MaxQuestionnairesPerClient int
+	// MaxQuestions is the default number of questions per questionnaire.
+	MaxQuestions int
+	// MaxQuestionsCeiling is the most questions a host can raise their questionnaire to.
+	MaxQuestionsCeiling int
+	// MaxClients is the number of clients per questionnaire.
+	MaxClients int
+	// MaxQuestionLength is the most characters a question can have.
+	MaxQuestionLength int
+
+	BallotGCInterval  time.Duration
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	ShutdownTimeout   time.Duration
 }
 
 func loadConfig() (*AppConfig, error) {
@@ -85,6 +161,113 @@ func loadConfig() (*AppConfig, error) {
 		return nil, err
 	}
 
+	voteFlushInterval, err := durationFromEnv("VOTE_FLUSH_INTERVAL", 200*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+
+	wsMaxConnsPerIP, err := intFromEnv("WS_MAX_CONNS_PER_IP", 500)
+	if err != nil {
+		return nil, err
+	}
+
+	questionnaireRate, err := rateFromEnv("RATE_QUESTIONNAIRES", "3/10m")
+	if err != nil {
+		return nil, err
+	}
+
+	questionRate, err := rateFromEnv("RATE_QUESTIONS", "10/1m")
+	if err != nil {
+		return nil, err
+	}
+
+	voteRate, err := rateFromEnv("RATE_VOTES", "60/1m")
+	if err != nil {
+		return nil, err
+	}
+
+	qrRate, err := rateFromEnv("RATE_QR", "30/1m")
+	if err != nil {
+		return nil, err
+	}
+
+	rateLimitByVoter := false
+	if byVoterStr := os.Getenv("RATE_LIMIT_BY_VOTER"); byVoterStr != "" {
+		rateLimitByVoter, err = strconv.ParseBool(byVoterStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rateLimitBackend := os.Getenv("RATE_LIMIT_BACKEND")
+	if rateLimitBackend == "" {
+		rateLimitBackend = "memory"
+	}
+	if rateLimitBackend != "memory" && rateLimitBackend != "redis" {
+		return nil, fmt.Errorf("invalid rate limit backend: %s", rateLimitBackend)
+	}
+
+	trustedProxies, err := parseCIDRs(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		return nil, fmt.Errorf("env var TRUSTED_PROXIES: %w", err)
+	}
+
+	trustedProxyHeader := xForwardedFor
+	if headerStr := os.Getenv("TRUSTED_PROXY_HEADER"); headerStr != "" {
+		trustedProxyHeader, err = parseProxyHeader(headerStr)
+		if err != nil {
+			return nil, fmt.Errorf("env var TRUSTED_PROXY_HEADER: %w", err)
+		}
+	}
+
+	// Replicas share their rate limits through Redis, and they must share the secrets too.
+	sharedSecrets := rateLimitBackend == "redis"
+	generatedSecrets := []string{}
+
+	powSecret, generated, err := secretFromEnv("POW_SECRET", sharedSecrets)
+	if err != nil {
+		return nil, err
+	}
+	if generated {
+		generatedSecrets = append(generatedSecrets, "POW_SECRET")
+	}
+
+	voterSecret, generated, err := secretFromEnv("VOTER_SECRET", sharedSecrets)
+	if err != nil {
+		return nil, err
+	}
+	if generated {
+		generatedSecrets = append(generatedSecrets, "VOTER_SECRET")
+	}
+
+	powDifficulty, err := intFromEnv("POW_DIFFICULTY", 16)
+	if err != nil {
+		return nil, err
+	}
+	if powDifficulty > 32 {
+		return nil, errors.New("env var POW_DIFFICULTY must be at most 32")
+	}
+
+	powTTL, err := durationFromEnv("POW_TTL", 2*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	anomaly, err := loadAnomaly()
+	if err != nil {
+		return nil, err
+	}
+
+	blocklist, err := loadBlocklist(os.Getenv("BLOCKLIST_FILE"))
+	if err != nil {
+		return nil, err
+	}
+
+	limits, err := loadLimits()
+	if err != nil {
+		return nil, err
+	}
+
 	return &AppConfig{
 		Domain:    domain,
 		Port:      port,
@@ -94,9 +277,211 @@ func loadConfig() (*AppConfig, error) {
 		RedisPort: redisPort,
 		RedisPass: redisPass,
 		LogLevel:  logLevel,
+
+		VoteFlushInterval:  voteFlushInterval,
+		WSMaxConnsPerIP:    wsMaxConnsPerIP,
+		QuestionnaireRate:  questionnaireRate,
+		QuestionRate:       questionRate,
+		VoteRate:           voteRate,
+		QRRate:             qrRate,
+		RateLimitByVoter:   rateLimitByVoter,
+		RateLimitBackend:   rateLimitBackend,
+		Limits:             limits,
+		TrustedProxies:     trustedProxies,
+		TrustedProxyHeader: trustedProxyHeader,
+		PowSecret:          powSecret,
+		PowDifficulty:      powDifficulty,
+		PowTTL:             powTTL,
+		VoterSecret:        voterSecret,
+		GeneratedSecrets:   generatedSecrets,
+		Anomaly:            anomaly,
+		Blocklist:          blocklist,
 	}, nil
 }
 
+func loadLimits() (Limits, error) {
+	var err error
+	l := Limits{}
+
+	l.MaxQuestionnaires, err = intFromEnv("MAX_QUESTIONNAIRES", 20)
+	if err != nil {
+		return Limits{}, err
+	}
+
+	l.MaxQuestionnairesPerClient, err = intFromEnv("MAX_QUESTIONNAIRES_PER_CLIENT", 3)
+	if err != nil {
+		return Limits{}, err
+	}
+
+	l.MaxQuestions, err = intFromEnv("MAX_QUESTIONS", 100)
+	if err != nil {
+		return Limits{}, err
+	}
+
+	l.MaxQuestionsCeiling, err = intFromEnv("MAX_QUESTIONS_CEILING", 500)
+	if err != nil {
+		return Limits{}, err
+	}
+	if l.MaxQuestionsCeiling < l.MaxQuestions {
+		return Limits{}, errors.New("env var MAX_QUESTIONS_CEILING must be at least MAX_QUESTIONS")
+	}
+
+	l.MaxClients, err = intFromEnv("MAX_CLIENTS", 100)
+	if err != nil {
+		return Limits{}, err
+	}
+
+	l.MaxQuestionLength, err = intFromEnv("MAX_QUESTION_LENGTH", 500)
+	if err != nil {
+		return Limits{}, err
+	}
+
+	l.BallotGCInterval, err = durationFromEnv("BALLOT_GC_INTERVAL", 10*time.Minute)
+	if err != nil {
+		return Limits{}, err
+	}
+
+	l.ReadHeaderTimeout, err = durationFromEnv("READ_HEADER_TIMEOUT", 2*time.Second)
+	if err != nil {
+		return Limits{}, err
+	}
+
+	l.ReadTimeout, err = durationFromEnv("READ_TIMEOUT", 5*time.Second)
+	if err != nil {
+		return Limits{}, err
+	}
+
+	l.WriteTimeout, err = durationFromEnv("WRITE_TIMEOUT", 5*time.Second)
+	if err != nil {
+		return Limits{}, err
+	}
+
+	l.ShutdownTimeout, err = durationFromEnv("SHUTDOWN_TIMEOUT", 5*time.Second)
+	if err != nil {
+		return Limits{}, err
+	}
+
+	return l, nil
+}
+
+func loadAnomaly() (questionnaire.AnalyzerConfig, error) {
+	var err error
+	a := questionnaire.AnalyzerConfig{}
+
+	a.Window, err = durationFromEnv("ANOMALY_WINDOW", time.Minute)
+	if err != nil {
+		return questionnaire.AnalyzerConfig{}, err
+	}
+
+	a.MaxVotesPerQuestion, err = intFromEnv("ANOMALY_MAX_VOTES_PER_QUESTION", 50)
+	if err != nil {
+		return questionnaire.AnalyzerConfig{}, err
+	}
+
+	a.MaxVotesPerIP, err = intFromEnv("ANOMALY_MAX_VOTES_PER_IP", 20)
+	if err != nil {
+		return questionnaire.AnalyzerConfig{}, err
+	}
+
+	a.MaxFreshVotesPerQuestion, err = intFromEnv("ANOMALY_MAX_FRESH_VOTES_PER_QUESTION", 10)
+	if err != nil {
+		return questionnaire.AnalyzerConfig{}, err
+	}
+
+	a.FreshVoterAge, err = durationFromEnv("ANOMALY_FRESH_VOTER_AGE", 2*time.Minute)
+	if err != nil {
+		return questionnaire.AnalyzerConfig{}, err
+	}
+
+	return a, nil
+}
+
+// loadBlocklist reads one blocked word per line from path, skipping blank lines and # comments.
+// An empty path means no blocklist.
+func loadBlocklist(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("env var BLOCKLIST_FILE: %w", err)
+	}
+
+	words := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+
+	err = questionnaire.ValidateBlocklist(words)
+	if err != nil {
+		return nil, fmt.Errorf("env var BLOCKLIST_FILE: %w", err)
+	}
+	return words, nil
+}
+
+// intFromEnv parses the positive integer in env var key, using def if it is not set.
+func intFromEnv(key string, def int) (int, error) {
+	str := os.Getenv(key)
+	if str == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("env var %s: %w", key, err)
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("env var %s must be positive", key)
+	}
+	return n, nil
+}
+
+// secretFromEnv reads the secret in env var key. If it is not set, a random one is
+// generated, unless it is required.
+func secretFromEnv(key string, required bool) (secret []byte, generated bool, err error) {
+	str := os.Getenv(key)
+	if str != "" {
+		return []byte(str), false, nil
+	}
+	if required {
+		return nil, false, fmt.Errorf("env var %s is required with RATE_LIMIT_BACKEND=redis, replicas must share it", key)
+	}
+	return []byte(uid.Generate(false, 32)), true, nil
+}
+
+// durationFromEnv parses the positive duration in env var key, using def if it is not set.
+func durationFromEnv(key string, def time.Duration) (time.Duration, error) {
+	str := os.Getenv(key)
+	if str == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		return 0, fmt.Errorf("env var %s: %w", key, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("env var %s must be positive", key)
+	}
+	return d, nil
+}
+
+// rateFromEnv parses the rate in env var key, using def if it is not set.
+func rateFromEnv(key string, def string) (ratelimit.Rate, error) {
+	str := os.Getenv(key)
+	if str == "" {
+		str = def
+	}
+	rate, err := ratelimit.ParseRate(str)
+	if err != nil {
+		return ratelimit.Rate{}, fmt.Errorf("env var %s: %w", key, err)
+	}
+	return rate, nil
+}
+
 func levelFromStr(str string) (slog.Level, error) {
 	switch str {
 	case "debug":
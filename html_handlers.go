@@ -1,13 +1,18 @@
 package main
 
 import (
+	"cmp"
 	"errors"
+	"fmt"
 	"html/template"
 	"net/http"
+	"slices"
 
+	"github.com/germandv/ama/internal/qr"
 	"github.com/germandv/ama/internal/questionnaire"
-	"github.com/germandv/ama/internal/uid"
+	"github.com/germandv/ama/internal/voter"
 	"github.com/germandv/ama/internal/webutils"
+	"github.com/skip2/go-qrcode"
 )
 
 func homePageHandler(web webutils.Web) http.HandlerFunc {
@@ -17,7 +22,7 @@ func homePageHandler(web webutils.Web) http.HandlerFunc {
 	}
 }
 
-func questionnairePageHandler(svc questionnaire.IService, web webutils.Web) http.HandlerFunc {
+func questionnairePageHandler(svc questionnaire.IService, voters *voter.Issuer, web webutils.Web) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tmpl := template.Must(template.ParseFiles("views/layout.html", "views/q.html"))
 
@@ -33,11 +38,12 @@ func questionnairePageHandler(svc questionnaire.IService, web webutils.Web) http
 			return
 		}
 
-		qs, err := svc.Get(questionnaireID)
+		qs, err := svc.Get(questionnaireID, participant(r, voters))
 		if err != nil {
 			web.InternalError(w, errors.New("error fetching existing questions"))
 			return
 		}
+		tag := r.URL.Query().Get("tag")
 
 		isHost := false
 		cookie, err := r.Cookie("host")
@@ -52,8 +58,8 @@ func questionnairePageHandler(svc questionnaire.IService, web webutils.Web) http
 		}
 
 		if !isHost && !hasVoterCookie {
-			voterID := uid.Generate(false, 32)
-			web.SetCookie(w, "voter", voterID)
+			// The signed timestamp tells the vote analyzer how fresh the cookie is.
+			web.SetCookie(w, "voter", voters.Issue())
 		}
 
 		data := map[string]any{
@@ -61,8 +67,137 @@ func questionnairePageHandler(svc questionnaire.IService, web webutils.Web) http
 			"ServerWS":  web.GetWebsocketURL(meta.ID),
 			"ID":        meta.ID,
 			"Title":     meta.Title,
-			"Questions": qs,
+			"Questions": questionnaire.ByTag(qs, tag),
 			"IsHost":    isHost,
+			"Tags":      meta.Settings.Tags,
+			"Tag":       tag,
+			"Panelists": meta.Settings.Panelists,
+			"Pinned":    meta.Pinned,
+			"Spotlight": spotlightOf(meta, qs),
+
+			"BotProtection": meta.Settings.BotProtection,
+		}
+
+		tmpl.Execute(w, data)
+	}
+}
+
+func panelistPageHandler(svc questionnaire.IService, voters *voter.Issuer, web webutils.Web) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tmpl := template.Must(template.ParseFiles("views/layout.html", "views/panelist.html"))
+
+		questionnaireID := r.PathValue("id")
+		if questionnaireID == "" {
+			web.BadRequest(w, errors.New("no questionnaire ID provided"))
+			return
+		}
+
+		meta, err := svc.GetMeta(questionnaireID)
+		if err != nil {
+			web.NotFound(w, "questionnaire", questionnaireID)
+			return
+		}
+
+		panelist := r.PathValue("panelist")
+		if !slices.Contains(meta.Settings.Panelists, panelist) {
+			web.NotFound(w, "panelist", panelist)
+			return
+		}
+
+		qs, err := svc.Get(questionnaireID, participant(r, voters))
+		if err != nil {
+			web.InternalError(w, errors.New("error fetching existing questions"))
+			return
+		}
+
+		// Panelists only care about the questions still open, the pinned one and then
+		// the most voted first.
+		open := []questionnaire.Question{}
+		for _, q := range questionnaire.ForPanelist(qs, panelist) {
+			if !q.Metadata.Answered {
+				open = append(open, q)
+			}
+		}
+		isPinned := func(q questionnaire.Question) int {
+			if q.ID == meta.Pinned {
+				return 1
+			}
+			return 0
+		}
+		slices.SortStableFunc(open, func(a, b questionnaire.Question) int {
+			return cmp.Or(cmp.Compare(isPinned(b), isPinned(a)), cmp.Compare(b.Metadata.Votes, a.Metadata.Votes))
+		})
+
+		data := map[string]any{
+			"ServerWS":  web.GetWebsocketURL(meta.ID),
+			"Title":     meta.Title,
+			"Panelist":  panelist,
+			"Questions": open,
+			"Pinned":    meta.Pinned,
+			"Spotlight": spotlightOf(meta, qs),
+		}
+
+		tmpl.Execute(w, data)
+	}
+}
+
+// spotlightOf returns the question in the spotlight, or a zero Question if there is none.
+func spotlightOf(meta questionnaire.Questionnaire, qs []questionnaire.Question) questionnaire.Question {
+	for _, q := range qs {
+		if meta.Spotlight != "" && q.ID == meta.Spotlight {
+			return q
+		}
+	}
+	return questionnaire.Question{}
+}
+
+func presentPageHandler(svc questionnaire.IService, voters *voter.Issuer, web webutils.Web) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tmpl := template.Must(template.ParseFiles("views/layout.html", "views/present.html"))
+
+		questionnaireID := r.PathValue("id")
+		if questionnaireID == "" {
+			web.BadRequest(w, errors.New("no questionnaire ID provided"))
+			return
+		}
+
+		meta, err := svc.GetMeta(questionnaireID)
+		if err != nil {
+			web.NotFound(w, "questionnaire", questionnaireID)
+			return
+		}
+
+		qs, err := svc.Get(questionnaireID, participant(r, voters))
+		if err != nil {
+			web.InternalError(w, errors.New("error fetching existing questions"))
+			return
+		}
+
+		open := []questionnaire.Question{}
+		for _, q := range qs {
+			if !q.Metadata.Answered {
+				open = append(open, q)
+			}
+		}
+		slices.SortStableFunc(open, func(a, b questionnaire.Question) int {
+			return cmp.Compare(b.Metadata.Votes, a.Metadata.Votes)
+		})
+
+		joinURL := fmt.Sprintf("%s/%s", web.GetApiURL(), meta.ID)
+		svg, err := qr.SVG(joinURL, qrcode.Medium, 0)
+		if err != nil {
+			web.InternalError(w, err)
+			return
+		}
+
+		data := map[string]any{
+			"ServerWS":  web.GetWebsocketURL(meta.ID),
+			"Title":     meta.Title,
+			"JoinURL":   joinURL,
+			"QR":        template.HTML(svg),
+			"Questions": open,
+			"Pinned":    meta.Pinned,
+			"Spotlight": spotlightOf(meta, qs),
 		}
 
 		tmpl.Execute(w, data)
@@ -0,0 +1,144 @@
+// Package pow implements a lightweight proof-of-work challenge to make
+// scripted floods expensive, without any third-party captcha service.
+//
+// The server issues a signed nonce, and the client has to find a counter such
+// that sha256("<nonce>:<counter>") starts with a number of zero bits. Nonces
+// carry their own expiration and signature so they need no storage until they
+// are redeemed, and each one can only be redeemed once.
+package pow
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/germandv/ama/internal/uid"
+)
+
+var (
+	ErrMissing  = errors.New("proof of work required")
+	ErrInvalid  = errors.New("invalid proof of work")
+	ErrExpired  = errors.New("proof of work challenge expired")
+	ErrRedeemed = errors.New("proof of work challenge already used")
+)
+
+// Challenge is what clients have to solve.
+type Challenge struct {
+	Nonce      string `json:"nonce"`
+	Difficulty int    `json:"difficulty"`
+}
+
+// Challenger issues and verifies challenges.
+type Challenger struct {
+	mu         sync.Mutex
+	secret     []byte
+	difficulty int
+	ttl        time.Duration
+	redeemed   map[string]time.Time
+}
+
+// New creates a Challenger that signs nonces with secret, requires difficulty
+// leading zero bits and accepts solutions up to ttl after the challenge was issued.
+func New(secret []byte, difficulty int, ttl time.Duration) *Challenger {
+	c := &Challenger{
+		secret:     secret,
+		difficulty: difficulty,
+		ttl:        ttl,
+		redeemed:   make(map[string]time.Time),
+	}
+
+	go c.gcRedeemed()
+	return c
+}
+
+// Issue creates a challenge bound to scope (e.g. a questionnaire ID).
+func (c *Challenger) Issue(scope string) Challenge {
+	exp := strconv.FormatInt(time.Now().Add(c.ttl).Unix(), 10)
+	random := uid.Generate(false, 16)
+	nonce := exp + "." + random + "." + c.sign(scope, exp, random)
+	return Challenge{Nonce: nonce, Difficulty: c.difficulty}
+}
+
+// Verify checks a solution in the form "<nonce>:<counter>" for scope,
+// and marks its nonce as redeemed.
+func (c *Challenger) Verify(scope string, solution string) error {
+	if solution == "" {
+		return ErrMissing
+	}
+
+	nonce, counter, found := strings.Cut(solution, ":")
+	if !found || counter == "" {
+		return ErrInvalid
+	}
+
+	parts := strings.Split(nonce, ".")
+	if len(parts) != 3 {
+		return ErrInvalid
+	}
+	exp, random, sig := parts[0], parts[1], parts[2]
+
+	if !hmac.Equal([]byte(sig), []byte(c.sign(scope, exp, random))) {
+		return ErrInvalid
+	}
+
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return ErrInvalid
+	}
+	expiration := time.Unix(expUnix, 0)
+	if time.Now().After(expiration) {
+		return ErrExpired
+	}
+
+	hash := sha256.Sum256([]byte(solution))
+	if leadingZeroBits(hash[:]) < c.difficulty {
+		return ErrInvalid
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, found := c.redeemed[nonce]; found {
+		return ErrRedeemed
+	}
+	c.redeemed[nonce] = expiration
+
+	return nil
+}
+
+func (c *Challenger) sign(scope string, exp string, random string) string {
+	mac := hmac.New(sha256.New, c.secret)
+	fmt.Fprintf(mac, "%s|%s|%s", scope, exp, random)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// gcRedeemed forgets redeemed nonces once they expire, they would be rejected anyway.
+func (c *Challenger) gcRedeemed() {
+	for range time.Tick(c.ttl) {
+		now := time.Now()
+		c.mu.Lock()
+		for nonce, exp := range c.redeemed {
+			if now.After(exp) {
+				delete(c.redeemed, nonce)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+func leadingZeroBits(b []byte) int {
+	n := 0
+	for _, x := range b {
+		if x != 0 {
+			return n + bits.LeadingZeros8(x)
+		}
+		n += 8
+	}
+	return n
+}
@@ -0,0 +1,269 @@
+// Package protocol defines the messages sent to WebSocket clients.
+//
+// Every message is an envelope with the protocol version, the event name and
+// the event details. The shape of every event is described by schema.json,
+// which is also served to clients, and the tests check that every message
+// conforms to it.
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Version is the current version of the protocol.
+const Version = 1
+
+// Subprotocol is the WebSocket subprotocol name for the current version.
+var Subprotocol = fmt.Sprintf("ama.v%d", Version)
+
+type Event string
+
+const (
+	EventNewQuestion   = Event("new_question")
+	EventVotes         = Event("votes")
+	EventAnswer        = Event("answer")
+	EventPresence      = Event("presence")
+	EventVoteFlag      = Event("vote_flag")
+	EventDuplicateFlag = Event("duplicate_flag")
+	EventMerged        = Event("questions_merged")
+	EventTag           = Event("tag")
+	EventPin           = Event("pin")
+	EventSpotlight     = Event("spotlight")
+)
+
+// Message is the envelope of every message sent to clients.
+type Message struct {
+	Version int   `json:"version"`
+	Event   Event `json:"event"`
+	Details any   `json:"details"`
+}
+
+type QuestionDetails struct {
+	ID       string `json:"id"`
+	Question string `json:"question"`
+	Votes    uint16 `json:"votes"`
+	Tag      string `json:"tag,omitempty"`
+	Panelist string `json:"panelist,omitempty"`
+}
+
+// NewQuestion creates the message sent when a question is asked. tag is empty for untagged
+// questions and panelist is empty for questions not addressed to a panelist.
+func NewQuestion(id string, question string, votes uint16, tag string, panelist string) Message {
+	return Message{
+		Version: Version,
+		Event:   EventNewQuestion,
+		Details: QuestionDetails{
+			ID:       id,
+			Question: question,
+			Votes:    votes,
+			Tag:      tag,
+			Panelist: panelist,
+		},
+	}
+}
+
+type VoteCount struct {
+	ID    string `json:"id"`
+	Votes uint16 `json:"votes"`
+}
+
+type VotesDetails struct {
+	Votes []VoteCount `json:"votes"`
+}
+
+// NewVotes creates the message with the latest vote counts, keyed by question ID.
+func NewVotes(counts map[string]uint16) Message {
+	votes := make([]VoteCount, 0, len(counts))
+	for id, count := range counts {
+		votes = append(votes, VoteCount{ID: id, Votes: count})
+	}
+
+	return Message{
+		Version: Version,
+		Event:   EventVotes,
+		Details: VotesDetails{
+			Votes: votes,
+		},
+	}
+}
+
+type AnswerDetails struct {
+	ID string `json:"id"`
+}
+
+// NewAnswer creates the message sent when a question is marked as answered.
+func NewAnswer(id string) Message {
+	return Message{
+		Version: Version,
+		Event:   EventAnswer,
+		Details: AnswerDetails{
+			ID: id,
+		},
+	}
+}
+
+type TagDetails struct {
+	ID  string `json:"id"`
+	Tag string `json:"tag"`
+}
+
+// NewTag creates the message sent when the host retags a question, tag is empty if it was untagged.
+func NewTag(id string, tag string) Message {
+	return Message{
+		Version: Version,
+		Event:   EventTag,
+		Details: TagDetails{
+			ID:  id,
+			Tag: tag,
+		},
+	}
+}
+
+type PinDetails struct {
+	ID string `json:"id"`
+}
+
+// NewPin creates the message sent when the host pins a question, id is empty if it was unpinned.
+func NewPin(id string) Message {
+	return Message{
+		Version: Version,
+		Event:   EventPin,
+		Details: PinDetails{
+			ID: id,
+		},
+	}
+}
+
+type SpotlightDetails struct {
+	ID       string `json:"id"`
+	Question string `json:"question"`
+}
+
+// NewSpotlight creates the message sent when the host puts a question in the spotlight,
+// id and question are empty if the spotlight was cleared.
+func NewSpotlight(id string, question string) Message {
+	return Message{
+		Version: Version,
+		Event:   EventSpotlight,
+		Details: SpotlightDetails{
+			ID:       id,
+			Question: question,
+		},
+	}
+}
+
+type PresenceDetails struct {
+	Count int `json:"count"`
+}
+
+// NewPresence creates the message with the number of clients in a room.
+func NewPresence(count int) Message {
+	return Message{
+		Version: Version,
+		Event:   EventPresence,
+		Details: PresenceDetails{
+			Count: count,
+		},
+	}
+}
+
+type VoteFlagDetails struct {
+	Kind          string `json:"kind"`
+	QuestionID    string `json:"question_id,omitempty"`
+	Count         int    `json:"count"`
+	WindowSeconds int    `json:"window_seconds"`
+}
+
+// NewVoteFlag creates the message sent to hosts when suspicious voting is detected.
+// questionID is empty for flags that are not about a single question.
+func NewVoteFlag(kind string, questionID string, count int, window time.Duration) Message {
+	return Message{
+		Version: Version,
+		Event:   EventVoteFlag,
+		Details: VoteFlagDetails{
+			Kind:          kind,
+			QuestionID:    questionID,
+			Count:         count,
+			WindowSeconds: int(window.Seconds()),
+		},
+	}
+}
+
+type DuplicateFlagDetails struct {
+	ID         string   `json:"id"`
+	Duplicates []string `json:"duplicates"`
+}
+
+// NewDuplicateFlag creates the message sent to hosts when a question looks like
+// the ones already asked.
+func NewDuplicateFlag(id string, duplicates []string) Message {
+	return Message{
+		Version: Version,
+		Event:   EventDuplicateFlag,
+		Details: DuplicateFlagDetails{
+			ID:         id,
+			Duplicates: duplicates,
+		},
+	}
+}
+
+type MergedDetails struct {
+	ID     string   `json:"id"`
+	Votes  uint16   `json:"votes"`
+	Merged []string `json:"merged"`
+}
+
+// NewMerged creates the message sent when questions are merged into the question id,
+// votes is the new vote count of that question.
+func NewMerged(id string, votes uint16, merged []string) Message {
+	return Message{
+		Version: Version,
+		Event:   EventMerged,
+		Details: MergedDetails{
+			ID:     id,
+			Votes:  votes,
+			Merged: merged,
+		},
+	}
+}
+
+// Encode marshals the message.
+func Encode(msg Message) ([]byte, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("encoding %s message: %w", msg.Event, err)
+	}
+	return data, nil
+}
+
+// Negotiate checks the protocol version requested by the client, either with the
+// Sec-WebSocket-Protocol header or the "v" query param. Clients not asking for a
+// version get the current one.
+// It returns the headers to include in the upgrade response.
+func Negotiate(r *http.Request) (http.Header, error) {
+	if v := r.URL.Query().Get("v"); v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil || version != Version {
+			return nil, fmt.Errorf("unsupported protocol version %q, supported: %d", v, Version)
+		}
+	}
+
+	requested := websocket.Subprotocols(r)
+	if len(requested) == 0 {
+		return nil, nil
+	}
+
+	if !slices.Contains(requested, Subprotocol) {
+		return nil, errors.New("unsupported subprotocol, supported: " + Subprotocol)
+	}
+
+	return http.Header{"Sec-Websocket-Protocol": {Subprotocol}}, nil
+}
@@ -0,0 +1,77 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessagesConformToSchema(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  Message
+	}{
+		{"new question", NewQuestion("q1", "How are you?", 0, "", "")},
+		{"new question with tag and panelist", NewQuestion("q1", "How are you?", 3, "infra", "Ada")},
+		{"votes", NewVotes(map[string]uint16{"q1": 1, "q2": 65535})},
+		{"no votes", NewVotes(nil)},
+		{"answer", NewAnswer("q1")},
+		{"tag", NewTag("q1", "infra")},
+		{"untag", NewTag("q1", "")},
+		{"pin", NewPin("q1")},
+		{"unpin", NewPin("")},
+		{"spotlight", NewSpotlight("q1", "How are you?")},
+		{"spotlight cleared", NewSpotlight("", "")},
+		{"presence", NewPresence(1)},
+		{"vote flag", NewVoteFlag("ip_burst", "", 21, time.Minute)},
+		{"vote flag on a question", NewVoteFlag("question_burst", "q1", 51, time.Minute)},
+		{"duplicate flag", NewDuplicateFlag("q2", []string{"q1"})},
+		{"questions merged", NewMerged("q1", 4, []string{"q2", "q3"})},
+	}
+
+	covered := map[Event]bool{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Encode(tt.msg)
+			if err != nil {
+				t.Fatalf("encoding: %s", err)
+			}
+			err = validate(data)
+			if err != nil {
+				t.Errorf("%s does not conform to the schema: %s", data, err)
+			}
+		})
+		covered[tt.msg.Event] = true
+	}
+
+	for _, event := range root.OneOf {
+		name := strings.TrimPrefix(event.Ref, "#/$defs/")
+		if !covered[Event(name)] {
+			t.Errorf("no test for event %q", name)
+		}
+	}
+}
+
+func TestSchemaRejectsInvalidMessages(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"unknown event", `{"version":1,"event":"unknown","details":{}}`},
+		{"wrong version", `{"version":2,"event":"answer","details":{"id":"q1"}}`},
+		{"missing details", `{"version":1,"event":"answer"}`},
+		{"missing property", `{"version":1,"event":"new_question","details":{"id":"q1","question":"How?"}}`},
+		{"extra property", `{"version":1,"event":"answer","details":{"id":"q1","author":"me"}}`},
+		{"empty ID", `{"version":1,"event":"answer","details":{"id":""}}`},
+		{"negative votes", `{"version":1,"event":"votes","details":{"votes":[{"id":"q1","votes":-1}]}}`},
+		{"null list", `{"version":1,"event":"questions_merged","details":{"id":"q1","votes":1,"merged":null}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if validate([]byte(tt.data)) == nil {
+				t.Errorf("%s conforms to the schema", tt.data)
+			}
+		})
+	}
+}
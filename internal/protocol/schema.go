@@ -0,0 +1,8 @@
+package protocol
+
+import _ "embed"
+
+// Schema is the JSON Schema describing every message of the current version.
+//
+//go:embed schema.json
+var Schema []byte
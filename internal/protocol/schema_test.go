@@ -0,0 +1,51 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// compiled is Schema ready to validate messages with.
+var compiled = mustCompileSchema(Schema)
+
+// root lists the events of Schema, so that tests can check all of them are covered.
+var root = mustParseEvents(Schema)
+
+func mustCompileSchema(data []byte) *jsonschema.Schema {
+	c := jsonschema.NewCompiler()
+	c.Draft = jsonschema.Draft2020
+	err := c.AddResource("schema.json", bytes.NewReader(data))
+	if err != nil {
+		panic(fmt.Sprintf("invalid protocol schema: %s", err))
+	}
+	return c.MustCompile("schema.json")
+}
+
+// events is the part of Schema listing the events, each a reference to its definition.
+type events struct {
+	OneOf []struct {
+		Ref string `json:"$ref"`
+	} `json:"oneOf"`
+}
+
+func mustParseEvents(data []byte) events {
+	e := events{}
+	err := json.Unmarshal(data, &e)
+	if err != nil {
+		panic(fmt.Sprintf("invalid protocol schema: %s", err))
+	}
+	return e
+}
+
+// validate checks an encoded message against Schema.
+func validate(data []byte) error {
+	var v any
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+	return compiled.Validate(v)
+}
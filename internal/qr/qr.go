@@ -0,0 +1,70 @@
+// Package qr renders QR codes, such as the ones pointing to a questionnaire.
+package qr
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// ParseLevel parses an error correction level: "low", "medium", "high" or "highest",
+// or their QR names "L", "M", "Q" and "H". Higher levels survive more damage to the code
+// but make it denser.
+func ParseLevel(s string) (qrcode.RecoveryLevel, error) {
+	switch strings.ToLower(s) {
+	case "low", "l":
+		return qrcode.Low, nil
+	case "medium", "m":
+		return qrcode.Medium, nil
+	case "high", "q":
+		return qrcode.High, nil
+	case "highest", "h":
+		return qrcode.Highest, nil
+	default:
+		return 0, fmt.Errorf("unknown error correction level %q, use low, medium, high or highest", s)
+	}
+}
+
+// PNG renders content as a PNG QR code, size pixels wide.
+func PNG(content string, level qrcode.RecoveryLevel, size int) ([]byte, error) {
+	return qrcode.Encode(content, level, size)
+}
+
+// SVG renders content as an SVG QR code. The image is size pixels wide, or scales
+// to its container if size is 0.
+func SVG(content string, level qrcode.RecoveryLevel, size int) ([]byte, error) {
+	code, err := qrcode.New(content, level)
+	if err != nil {
+		return nil, err
+	}
+	bitmap := code.Bitmap()
+	modules := len(bitmap)
+
+	// A single path with a rectangle per run of dark modules in every row keeps the SVG small.
+	path := strings.Builder{}
+	for y, row := range bitmap {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+
+	dimensions := ""
+	if size > 0 {
+		dimensions = fmt.Sprintf(` width="%d" height="%d"`, size, size)
+	}
+
+	svg := fmt.Sprintf(
+		`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d"%s shape-rendering="crispEdges">`+
+			`<rect width="100%%" height="100%%" fill="#fff"/><path fill="#000" d="%s"/></svg>`,
+		modules, modules, dimensions, path.String(),
+	)
+	return []byte(svg), nil
+}
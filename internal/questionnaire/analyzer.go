@@ -0,0 +1,157 @@
+package questionnaire
+
+import (
+	"sync"
+	"time"
+)
+
+type FlagKind string
+
+const (
+	// FlagQuestionBurst is raised when a question gets too many votes too fast.
+	FlagQuestionBurst = FlagKind("question_burst")
+	// FlagIPBurst is raised when a single IP casts too many votes too fast.
+	FlagIPBurst = FlagKind("ip_burst")
+	// FlagFreshVoters is raised when a question gets too many votes from brand new voter
+	// cookies, which is what clearing cookies between votes looks like.
+	FlagFreshVoters = FlagKind("fresh_voters")
+)
+
+// Flag reports suspicious voting activity to the host.
+type Flag struct {
+	Questionnaire string
+	Question      string
+	Kind          FlagKind
+	Count         int
+	Window        time.Duration
+}
+
+// AnalyzerConfig sets the thresholds above which voting activity is flagged.
+type AnalyzerConfig struct {
+	Window                   time.Duration
+	MaxVotesPerQuestion      int
+	MaxVotesPerIP            int
+	MaxFreshVotesPerQuestion int
+	FreshVoterAge            time.Duration
+}
+
+// Analyzer watches vote rates per question, per IP and per fresh voter cookie,
+// and flags bursts within a sliding window.
+type Analyzer struct {
+	mu     sync.Mutex
+	cfg    AnalyzerConfig
+	onFlag func(Flag)
+	// issuedAt tells when a voter cookie was issued, false if the server did not issue it.
+	issuedAt func(voter string) (time.Time, bool)
+	votes    map[string][]time.Time
+	flagged  map[string]time.Time
+}
+
+// NewAnalyzer creates an Analyzer that calls onFlag for every new flag,
+// issuedAt tells when a voter cookie was issued.
+func NewAnalyzer(cfg AnalyzerConfig, issuedAt func(voter string) (time.Time, bool), onFlag func(Flag)) *Analyzer {
+	a := &Analyzer{
+		cfg:      cfg,
+		onFlag:   onFlag,
+		issuedAt: issuedAt,
+		votes:    make(map[string][]time.Time),
+		flagged:  make(map[string]time.Time),
+	}
+
+	go a.gcExpiredVotes()
+	return a
+}
+
+// Observe records a vote and returns true if it comes from a flagged source:
+// an IP casting a burst of votes, or a fresh voter on a question getting a burst of those.
+func (a *Analyzer) Observe(questionnaireID string, questionID string, voter Participant) bool {
+	now := time.Now()
+	flags := []Flag{}
+
+	a.mu.Lock()
+
+	questionKey := "q:" + questionnaireID + ":" + questionID
+	if count, isNew := a.record(questionKey, now, a.cfg.MaxVotesPerQuestion); isNew {
+		flags = append(flags, Flag{Kind: FlagQuestionBurst, Question: questionID, Count: count})
+	}
+
+	ipKey := "ip:" + questionnaireID + ":" + voter.IP
+	if count, isNew := a.record(ipKey, now, a.cfg.MaxVotesPerIP); isNew {
+		flags = append(flags, Flag{Kind: FlagIPBurst, Count: count})
+	}
+	suspicious := a.isFlagged(ipKey, now)
+
+	if a.isFresh(voter, now) {
+		freshKey := "fresh:" + questionnaireID + ":" + questionID
+		if count, isNew := a.record(freshKey, now, a.cfg.MaxFreshVotesPerQuestion); isNew {
+			flags = append(flags, Flag{Kind: FlagFreshVoters, Question: questionID, Count: count})
+		}
+		suspicious = suspicious || a.isFlagged(freshKey, now)
+	}
+
+	a.mu.Unlock()
+
+	for _, f := range flags {
+		f.Questionnaire = questionnaireID
+		f.Window = a.cfg.Window
+		a.onFlag(f)
+	}
+
+	return suspicious
+}
+
+// record adds a vote to key and returns the count in the window,
+// and true if the key went over limit and was not already flagged.
+func (a *Analyzer) record(key string, now time.Time, limit int) (int, bool) {
+	votes := append(prune(a.votes[key], now.Add(-a.cfg.Window)), now)
+	a.votes[key] = votes
+
+	if len(votes) <= limit || a.isFlagged(key, now) {
+		return len(votes), false
+	}
+
+	a.flagged[key] = now.Add(a.cfg.Window)
+	return len(votes), true
+}
+
+func (a *Analyzer) isFlagged(key string, now time.Time) bool {
+	until, found := a.flagged[key]
+	return found && now.Before(until)
+}
+
+// isFresh tells if the voter cookie was issued recently.
+// Cookies without a valid signature were not issued by the server, so they are fresh too.
+func (a *Analyzer) isFresh(voter Participant, now time.Time) bool {
+	issued, ok := a.issuedAt(voter.Voter)
+	return !ok || now.Sub(issued) < a.cfg.FreshVoterAge
+}
+
+// prune drops the votes before since, votes are sorted by time.
+func prune(votes []time.Time, since time.Time) []time.Time {
+	i := 0
+	for i < len(votes) && votes[i].Before(since) {
+		i++
+	}
+	return votes[i:]
+}
+
+func (a *Analyzer) gcExpiredVotes() {
+	for range time.Tick(a.cfg.Window) {
+		now := time.Now()
+		a.mu.Lock()
+		for key, votes := range a.votes {
+			votes = prune(votes, now.Add(-a.cfg.Window))
+			if len(votes) == 0 {
+				delete(a.votes, key)
+			} else {
+				a.votes[key] = votes
+			}
+		}
+		for key, until := range a.flagged {
+			if now.After(until) {
+				delete(a.flagged, key)
+			}
+		}
+		a.mu.Unlock()
+	}
+}
@@ -0,0 +1,49 @@
+package questionnaire
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrBanned is returned when a banned participant asks or votes.
+var ErrBanned = errors.New("you have been banned from this questionnaire")
+
+// Ban keeps a participant from taking part in a questionnaire.
+// A shadow ban lets them keep asking, but their questions are only visible to themselves.
+type Ban struct {
+	Voter     string    `json:"voter,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	Shadow    bool      `json:"shadow"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func NewBan(p Participant, shadow bool) Ban {
+	return Ban{
+		Voter:     p.Voter,
+		IP:        p.IP,
+		Shadow:    shadow,
+		CreatedAt: time.Now(),
+	}
+}
+
+// Matches returns true if the ban applies to the participant, by voter cookie or IP.
+func (b Ban) Matches(p Participant) bool {
+	return (b.Voter != "" && b.Voter == p.Voter) || (b.IP != "" && b.IP == p.IP)
+}
+
+// banFor returns the ban that applies to the participant, if any.
+// Full bans take precedence over shadow bans.
+func banFor(bans []Ban, p Participant) (Ban, bool) {
+	found := false
+	match := Ban{}
+	for _, b := range bans {
+		if !b.Matches(p) {
+			continue
+		}
+		if !b.Shadow {
+			return b, true
+		}
+		match, found = b, true
+	}
+	return match, found
+}
@@ -0,0 +1,219 @@
+package questionnaire
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+type RejectionReason string
+
+const (
+	ReasonTooShort = RejectionReason("too_short")
+	ReasonTooLong  = RejectionReason("too_long")
+	ReasonBlocked  = RejectionReason("blocked_word")
+)
+
+// RejectionError is returned when a question does not pass the content filter.
+// It is meant to be sent to the client as is.
+type RejectionError struct {
+	Reason  RejectionReason `json:"reason"`
+	Message string          `json:"message"`
+	Limit   int             `json:"limit,omitempty"`
+}
+
+func (e *RejectionError) Error() string {
+	return e.Message
+}
+
+type BlockMode string
+
+const (
+	// BlockModeReplace masks blocked words with asterisks.
+	BlockModeReplace = BlockMode("replace")
+	// BlockModeReject rejects questions with blocked words.
+	BlockModeReject = BlockMode("reject")
+)
+
+// FilterSettings are the per-questionnaire options of the content filter.
+type FilterSettings struct {
+	MinLength int `json:"min_length,omitempty"`
+	// MaxLength can only lower the server's maximum length.
+	MaxLength int  `json:"max_length,omitempty"`
+	StripURLs bool `json:"strip_urls,omitempty"`
+	// Blocklist is added to the server's blocklist.
+	Blocklist []string  `json:"blocklist,omitempty"`
+	BlockMode BlockMode `json:"block_mode,omitempty"`
+}
+
+// Filter transforms the text of a question, or rejects it with a RejectionError.
+type Filter interface {
+	Apply(text string) (string, error)
+}
+
+// FilterFunc adapts a function to the Filter interface.
+type FilterFunc func(text string) (string, error)
+
+func (f FilterFunc) Apply(text string) (string, error) {
+	return f(text)
+}
+
+// Pipeline runs filters in order, stopping at the first rejection.
+type Pipeline []Filter
+
+func (p Pipeline) Apply(text string) (string, error) {
+	var err error
+	for _, f := range p {
+		text, err = f.Apply(text)
+		if err != nil {
+			return "", err
+		}
+	}
+	return text, nil
+}
+
+// Normalize converts text to Unicode NFC, so the same characters composed differently
+// (e.g. "é" as one rune or as "e" and a combining accent) compare equal.
+func Normalize() Filter {
+	return FilterFunc(func(text string) (string, error) {
+		return norm.NFC.String(text), nil
+	})
+}
+
+// StripControl drops control and invisible formatting characters (e.g. zero-width spaces),
+// and collapses whitespace, including line breaks, into single spaces.
+func StripControl() Filter {
+	return FilterFunc(func(text string) (string, error) {
+		sb := strings.Builder{}
+		sb.Grow(len(text))
+		for _, r := range text {
+			if unicode.IsSpace(r) {
+				sb.WriteRune(' ')
+				continue
+			}
+			if r == utf8.RuneError || unicode.Is(unicode.Cc, r) || unicode.Is(unicode.Cf, r) {
+				continue
+			}
+			sb.WriteRune(r)
+		}
+		return strings.Join(strings.Fields(sb.String()), " "), nil
+	})
+}
+
+var urlRegexp = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+// StripURLs removes links.
+func StripURLs() Filter {
+	return FilterFunc(func(text string) (string, error) {
+		text = urlRegexp.ReplaceAllString(text, "")
+		return strings.Join(strings.Fields(text), " "), nil
+	})
+}
+
+// LengthLimit rejects text shorter than min or longer than max characters.
+func LengthLimit(min int, max int) Filter {
+	return FilterFunc(func(text string) (string, error) {
+		length := utf8.RuneCountInString(text)
+		if length < min {
+			msg := fmt.Sprintf("questions must have at least %d characters", min)
+			if min <= 1 {
+				msg = "questions cannot be empty"
+			}
+			return "", &RejectionError{
+				Reason:  ReasonTooShort,
+				Message: msg,
+				Limit:   min,
+			}
+		}
+		if max > 0 && length > max {
+			return "", &RejectionError{
+				Reason:  ReasonTooLong,
+				Message: fmt.Sprintf("questions can have at most %d characters", max),
+				Limit:   max,
+			}
+		}
+		return text, nil
+	})
+}
+
+// Blocklist masks or rejects words in the list, matching whole words regardless of case
+// and of compatibility variants (e.g. fullwidth "ｗｏｒｄ" matches "word").
+func Blocklist(words []string, mode BlockMode) Filter {
+	blocked := make(map[string]bool, len(words))
+	for _, w := range words {
+		w = foldWord(strings.TrimSpace(w))
+		if w != "" {
+			blocked[w] = true
+		}
+	}
+
+	return FilterFunc(func(text string) (string, error) {
+		if len(blocked) == 0 {
+			return text, nil
+		}
+
+		sb := strings.Builder{}
+		sb.Grow(len(text))
+		for _, tok := range splitWords(text) {
+			if !tok.word || !blocked[foldWord(tok.text)] {
+				sb.WriteString(tok.text)
+				continue
+			}
+			if mode == BlockModeReject {
+				return "", &RejectionError{
+					Reason:  ReasonBlocked,
+					Message: "your question contains words that are not allowed",
+				}
+			}
+			sb.WriteString(strings.Repeat("*", utf8.RuneCountInString(tok.text)))
+		}
+		return sb.String(), nil
+	})
+}
+
+// ValidateBlocklist checks that every entry is a single word, as Blocklist only
+// matches whole words and entries with spaces or punctuation would never match.
+func ValidateBlocklist(words []string) error {
+	for _, w := range words {
+		tokens := splitWords(foldWord(strings.TrimSpace(w)))
+		if len(tokens) > 1 || (len(tokens) == 1 && !tokens[0].word) {
+			return fmt.Errorf("blocklist entry %q must be a single word", w)
+		}
+	}
+	return nil
+}
+
+// foldWord makes the comparison of words case insensitive and NFKC
+// normalized, which maps compatibility variants to the plain letters.
+func foldWord(w string) string {
+	return strings.ToLower(norm.NFKC.String(w))
+}
+
+type token struct {
+	text string
+	word bool
+}
+
+// splitWords splits text into runs of letters/digits and runs of everything else,
+// so that joining the tokens gives back the text.
+func splitWords(text string) []token {
+	tokens := []token{}
+	start := 0
+	inWord := false
+	for i, r := range text {
+		isWordRune := unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+		if i > 0 && isWordRune != inWord {
+			tokens = append(tokens, token{text: text[start:i], word: inWord})
+			start = i
+		}
+		inWord = isWordRune
+	}
+	if start < len(text) {
+		tokens = append(tokens, token{text: text[start:], word: inWord})
+	}
+	return tokens
+}
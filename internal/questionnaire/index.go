@@ -0,0 +1,231 @@
+package questionnaire
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// minSuggestScore is the share of the typed text's trigrams a question must contain to be suggested.
+	minSuggestScore = 0.4
+	// indexRefreshInterval is how often questionnaires are reloaded from the repository,
+	// to pick up the changes made through other replicas.
+	indexRefreshInterval = time.Minute
+)
+
+// indexedQuestion is a question along with its trigrams.
+type indexedQuestion struct {
+	question Question
+	trigrams map[string]bool
+	// updated is when the entry last changed, removed entries are kept until the next load.
+	updated time.Time
+	removed bool
+}
+
+// questionnaireIndex holds the questions of a questionnaire, by question ID, and its bans.
+type questionnaireIndex struct {
+	expiration time.Time
+	loaded     time.Time
+	questions  map[string]*indexedQuestion
+	bans       []Ban
+}
+
+// Index keeps the questions of every questionnaire in memory along with their trigrams,
+// so that questions matching what a participant is typing are found without a round trip
+// to the repository.
+type Index struct {
+	mu             sync.RWMutex
+	ttl            time.Duration
+	questionnaires map[string]*questionnaireIndex
+}
+
+// NewIndex creates an Index that forgets questionnaires ttl after they were first indexed,
+// when they would have expired from the repository too.
+func NewIndex(ttl time.Duration, gcInterval time.Duration) *Index {
+	idx := &Index{
+		ttl:            ttl,
+		questionnaires: make(map[string]*questionnaireIndex),
+	}
+
+	go idx.gcExpired(gcInterval)
+	return idx
+}
+
+// Stale tells if the questionnaire has to be loaded, because it was never loaded
+// or because it was loaded too long ago.
+func (idx *Index) Stale(questionnaireID string) bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	qi, found := idx.questionnaires[questionnaireID]
+	return !found || time.Since(qi.loaded) > indexRefreshInterval
+}
+
+// Load replaces the questions and bans of a questionnaire with the ones fetched from the
+// repository at fetched. Changes made to the index after that are newer, so they are kept.
+func (idx *Index) Load(questionnaireID string, fetched time.Time, qs []Question, bans []Ban) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	loaded := &questionnaireIndex{
+		expiration: time.Now().Add(idx.ttl),
+		loaded:     fetched,
+		questions:  make(map[string]*indexedQuestion, len(qs)),
+		bans:       bans,
+	}
+	for _, q := range qs {
+		loaded.questions[q.ID] = newIndexedQuestion(q, fetched)
+	}
+
+	if qi, found := idx.questionnaires[questionnaireID]; found {
+		loaded.expiration = qi.expiration
+		for id, iq := range qi.questions {
+			if iq.updated.After(fetched) {
+				loaded.questions[id] = iq
+			}
+		}
+		for _, b := range qi.bans {
+			if b.CreatedAt.After(fetched) {
+				loaded.bans = append(loaded.bans, b)
+			}
+		}
+	}
+
+	idx.questionnaires[questionnaireID] = loaded
+}
+
+func newIndexedQuestion(q Question, updated time.Time) *indexedQuestion {
+	return &indexedQuestion{
+		question: q,
+		trigrams: trigrams(normalizeTokens(q.Question)),
+		updated:  updated,
+		removed:  q.MergedInto != "",
+	}
+}
+
+// Add indexes new questions, or replaces the ones already indexed.
+// Questionnaires that were not loaded are left alone, they are loaded whole when needed.
+func (idx *Index) Add(questionnaireID string, qs ...Question) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	qi, found := idx.questionnaires[questionnaireID]
+	if !found {
+		return
+	}
+	now := time.Now()
+	for _, q := range qs {
+		qi.questions[q.ID] = newIndexedQuestion(q, now)
+	}
+}
+
+// Update changes an indexed question with fn.
+func (idx *Index) Update(questionnaireID string, questionID string, fn func(q *Question)) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	qi, found := idx.questionnaires[questionnaireID]
+	if !found {
+		return
+	}
+	iq, found := qi.questions[questionID]
+	if !found {
+		return
+	}
+	fn(&iq.question)
+	iq.updated = time.Now()
+}
+
+// Remove drops questions from the index.
+func (idx *Index) Remove(questionnaireID string, questionIDs ...string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	qi, found := idx.questionnaires[questionnaireID]
+	if !found {
+		return
+	}
+	now := time.Now()
+	for _, id := range questionIDs {
+		if iq, found := qi.questions[id]; found {
+			iq.removed = true
+			iq.updated = now
+		} else {
+			qi.questions[id] = &indexedQuestion{removed: true, updated: now}
+		}
+	}
+}
+
+// Ban adds a ban to the questionnaire, hiding the questions of the banned participant.
+func (idx *Index) Ban(questionnaireID string, b Ban) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	qi, found := idx.questionnaires[questionnaireID]
+	if !found {
+		return
+	}
+	qi.bans = append(qi.bans, b)
+}
+
+// Search returns up to limit unanswered questions visible to viewer that match text,
+// best matches first. The score is the share of the trigrams of text found in the question,
+// so that partially typed questions already match.
+func (idx *Index) Search(questionnaireID string, text string, viewer Participant, limit int) []Match {
+	query := trigrams(normalizeTokens(text))
+	if len(query) == 0 {
+		return []Match{}
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	qi, found := idx.questionnaires[questionnaireID]
+	if !found {
+		return []Match{}
+	}
+
+	matches := []Match{}
+	for _, iq := range qi.questions {
+		if iq.removed || iq.question.Metadata.Answered {
+			continue
+		}
+		q, ok := visibleTo(iq.question, qi.bans, viewer)
+		if !ok {
+			continue
+		}
+
+		shared := 0
+		for t := range query {
+			if iq.trigrams[t] {
+				shared++
+			}
+		}
+		score := float64(shared) / float64(len(query))
+		if score >= minSuggestScore {
+			matches = append(matches, Match{ID: q.ID, Question: q.Question, Votes: q.Metadata.Votes, Score: score})
+		}
+	}
+
+	slices.SortFunc(matches, func(a, b Match) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), cmp.Compare(a.ID, b.ID))
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+func (idx *Index) gcExpired(interval time.Duration) {
+	for range time.Tick(interval) {
+		now := time.Now()
+		idx.mu.Lock()
+		for id, qi := range idx.questionnaires {
+			if now.After(qi.expiration) {
+				delete(idx.questionnaires, id)
+			}
+		}
+		idx.mu.Unlock()
+	}
+}
@@ -1,18 +1,52 @@
 package questionnaire
 
+import "time"
+
 type Metadata struct {
 	Votes    uint16 `json:"votes"`
 	Answered bool   `json:"answered"`
 }
 
+// Participant identifies someone asking or voting.
+type Participant struct {
+	Voter string `json:"voter,omitempty"`
+	IP    string `json:"ip,omitempty"`
+	// Verified tells if the voter cookie was issued by the server. Anyone can make one
+	// up, so unverified ones are not trusted to tell participants apart.
+	Verified bool `json:"verified,omitempty"`
+}
+
+// Key identifies the participant by their voter cookie, or by their IP if the cookie
+// is missing or was not issued by the server.
+func (p Participant) Key() string {
+	if p.Voter != "" && p.Verified {
+		return "voter:" + p.Voter
+	}
+	return "ip:" + p.IP
+}
+
 type Question struct {
-	ID            string   `json:"id"`
-	Questionnaire string   `json:"questionnaire"`
-	Question      string   `json:"question"`
-	Metadata      Metadata `json:"metadata"`
+	ID            string    `json:"id"`
+	Questionnaire string    `json:"questionnaire"`
+	Question      string    `json:"question"`
+	Metadata      Metadata  `json:"metadata"`
+	CreatedAt     time.Time `json:"created_at"`
+	// Tag is one of the tags of the questionnaire, or empty.
+	Tag string `json:"tag,omitempty"`
+	// Panelist is who the question is addressed to, empty if it is for anyone.
+	Panelist string `json:"panelist,omitempty"`
+	// PossibleDuplicates are the IDs of similar questions asked before this one.
+	// Only hosts are told about them, so it is not exposed to clients either.
+	PossibleDuplicates []string `json:"-"`
+	// MergedInto is the ID of the question this one was merged into, merged questions are hidden.
+	MergedInto string `json:"merged_into,omitempty"`
+	// Author is kept by repositories but never exposed to clients.
+	Author Participant `json:"-"`
+	// Shadowed is set on questions whose author is shadow banned, they are only visible to the author.
+	Shadowed bool `json:"-"`
 }
 
-func NewQuestion(id string, questionnaire string, question string) Question {
+func NewQuestion(id string, questionnaire string, question string, author Participant) Question {
 	return Question{
 		ID:            id,
 		Questionnaire: questionnaire,
@@ -21,5 +55,35 @@ func NewQuestion(id string, questionnaire string, question string) Question {
 			Votes:    0,
 			Answered: false,
 		},
+		CreatedAt: time.Now(),
+		Author:    author,
+	}
+}
+
+// ByTag returns the questions with the given tag, or all of them if tag is empty.
+func ByTag(qs []Question, tag string) []Question {
+	if tag == "" {
+		return qs
+	}
+	tagged := make([]Question, 0, len(qs))
+	for _, q := range qs {
+		if q.Tag == tag {
+			tagged = append(tagged, q)
+		}
+	}
+	return tagged
+}
+
+// ForPanelist returns the questions addressed to panelist, or all of them if panelist is empty.
+func ForPanelist(qs []Question, panelist string) []Question {
+	if panelist == "" {
+		return qs
+	}
+	addressed := make([]Question, 0, len(qs))
+	for _, q := range qs {
+		if q.Panelist == panelist {
+			addressed = append(addressed, q)
+		}
 	}
+	return addressed
 }
@@ -2,16 +2,116 @@ package questionnaire
 
 import "github.com/germandv/ama/internal/uid"
 
+// Settings are the options a host can tune for their questionnaire.
+// Zero values mean the server defaults apply.
+type Settings struct {
+	MaxQuestions int `json:"max_questions,omitempty"`
+	// BotProtection requires a proof of work to ask and vote.
+	BotProtection bool `json:"bot_protection,omitempty"`
+	// SlowMode limits how often each participant can ask.
+	SlowMode SlowMode `json:"slow_mode"`
+	// DiscountFlaggedVotes ignores votes from sources flagged by the vote analyzer.
+	DiscountFlaggedVotes bool `json:"discount_flagged_votes,omitempty"`
+	// Filter tunes the content filter applied to new questions.
+	Filter FilterSettings `json:"filter"`
+	// Duplicates tunes the detection of questions similar to existing ones.
+	Duplicates DuplicateSettings `json:"duplicates"`
+	// Tags are the topics askers can file their questions under.
+	Tags []string `json:"tags,omitempty"`
+	// Panelists are the speakers askers can address their questions to.
+	Panelists []string `json:"panelists,omitempty"`
+}
+
+// SlowMode stops a single participant from dominating the queue.
+type SlowMode struct {
+	// CooldownSeconds is the minimum time between questions of the same participant.
+	CooldownSeconds int `json:"cooldown_seconds,omitempty"`
+	// MaxOpenQuestions is how many unanswered questions a participant can have.
+	MaxOpenQuestions int `json:"max_open_questions,omitempty"`
+}
+
+// SettingsPatch changes some of the settings of a questionnaire,
+// nil fields leave the current values untouched.
+type SettingsPatch struct {
+	MaxQuestions         *int                    `json:"max_questions"`
+	BotProtection        *bool                   `json:"bot_protection"`
+	SlowMode             *SlowModePatch          `json:"slow_mode"`
+	DiscountFlaggedVotes *bool                   `json:"discount_flagged_votes"`
+	Filter               *FilterSettingsPatch    `json:"filter"`
+	Duplicates           *DuplicateSettingsPatch `json:"duplicates"`
+	Tags                 *[]string               `json:"tags"`
+	Panelists            *[]string               `json:"panelists"`
+}
+
+type SlowModePatch struct {
+	CooldownSeconds  *int `json:"cooldown_seconds"`
+	MaxOpenQuestions *int `json:"max_open_questions"`
+}
+
+type FilterSettingsPatch struct {
+	MinLength *int       `json:"min_length"`
+	MaxLength *int       `json:"max_length"`
+	StripURLs *bool      `json:"strip_urls"`
+	Blocklist *[]string  `json:"blocklist"`
+	BlockMode *BlockMode `json:"block_mode"`
+}
+
+type DuplicateSettingsPatch struct {
+	Mode      *DuplicateMode `json:"mode"`
+	Threshold *float64       `json:"threshold"`
+}
+
+// Apply returns settings with the changes of the patch.
+func (p SettingsPatch) Apply(settings Settings) Settings {
+	override(&settings.MaxQuestions, p.MaxQuestions)
+	override(&settings.BotProtection, p.BotProtection)
+	if p.SlowMode != nil {
+		override(&settings.SlowMode.CooldownSeconds, p.SlowMode.CooldownSeconds)
+		override(&settings.SlowMode.MaxOpenQuestions, p.SlowMode.MaxOpenQuestions)
+	}
+	override(&settings.DiscountFlaggedVotes, p.DiscountFlaggedVotes)
+	if p.Filter != nil {
+		override(&settings.Filter.MinLength, p.Filter.MinLength)
+		override(&settings.Filter.MaxLength, p.Filter.MaxLength)
+		override(&settings.Filter.StripURLs, p.Filter.StripURLs)
+		override(&settings.Filter.Blocklist, p.Filter.Blocklist)
+		override(&settings.Filter.BlockMode, p.Filter.BlockMode)
+	}
+	if p.Duplicates != nil {
+		override(&settings.Duplicates.Mode, p.Duplicates.Mode)
+		override(&settings.Duplicates.Threshold, p.Duplicates.Threshold)
+	}
+	override(&settings.Tags, p.Tags)
+	override(&settings.Panelists, p.Panelists)
+	return settings
+}
+
+// override overwrites dst with val when val is not nil.
+func override[T any](dst *T, val *T) {
+	if val != nil {
+		*dst = *val
+	}
+}
+
 type Questionnaire struct {
-	ID    string `json:"id"`
-	Title string `json:"title"`
-	Host  string `json:"host"`
+	ID       string   `json:"id"`
+	Title    string   `json:"title"`
+	Host     string   `json:"host"`
+	Settings Settings `json:"settings"`
+	// Creator identifies the client that created the questionnaire (i.e. its IP).
+	Creator string `json:"creator,omitempty"`
+	// Pinned is the ID of the question the host pinned to the top, if any.
+	Pinned string `json:"pinned,omitempty"`
+	// Spotlight is the ID of the question being answered right now, if any.
+	Spotlight string `json:"spotlight,omitempty"`
 }
 
-func NewQuestionnaire(title string) Questionnaire {
+func NewQuestionnaire(title string, settings Settings, creator string) Questionnaire {
 	return Questionnaire{
-		ID:    uid.Generate(true, 16),
-		Title: title,
-		Host:  uid.Generate(false, 32),
+		ID:       uid.Generate(true, 16),
+		Title:    title,
+		Host:     uid.Generate(false, 32),
+		Settings: settings,
+		Creator:  creator,
 	}
 }
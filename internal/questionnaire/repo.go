@@ -2,11 +2,26 @@ package questionnaire
 
 type Repository interface {
 	SaveQuestionnaire(q Questionnaire) error
+	// UpdateQuestionnaire applies update to the stored questionnaire atomically.
+	UpdateQuestionnaire(questionnaireID string, update func(q *Questionnaire) error) (Questionnaire, error)
 	SaveQuestion(questionnaireID string, q Question) error
 	GetQuestions(questionnaireID string) ([]Question, error)
 	GetQuestionnaire(questionnaireID string) (Questionnaire, error)
 	CountQuestionnaires() (int, error)
+	CountQuestionnairesByCreator(creator string) (int, error)
 	CountQuestions(questionnaireID string) (int, error)
 	Vote(questionnaireID string, questionID string) (uint16, error)
 	Answer(questionnaireID string, questionID string) error
+	Tag(questionnaireID string, questionID string, tag string) error
+	// Merge atomically points the merged questions to the target and adds their votes to the
+	// target's count, minus overlap votes cast by voters who voted more than one of them.
+	// It fails if any of the questions was already merged.
+	Merge(questionnaireID string, targetID string, mergedIDs []string, overlap int) (Question, error)
+	// Search returns the questions matching the query, best matches first.
+	Search(questionnaireID string, sq SearchQuery) ([]SearchResult, error)
+	SaveBan(questionnaireID string, b Ban) error
+	GetBans(questionnaireID string) ([]Ban, error)
+	// Lock takes an exclusive lock on the questionnaire, shared by every replica
+	// using the same store, until unlock is called.
+	Lock(questionnaireID string) (unlock func(), err error)
 }
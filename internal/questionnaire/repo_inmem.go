@@ -2,6 +2,8 @@ package questionnaire
 
 import (
 	"fmt"
+	"math"
+	"slices"
 	"sync"
 )
 
@@ -9,12 +11,27 @@ type InMemoryRepository struct {
 	mu             sync.RWMutex
 	questionnaires map[string]Questionnaire
 	questions      map[string][]Question
+	bans           map[string][]Ban
+	// index maps the terms of every questionnaire to the IDs of the questions containing them.
+	index map[string]map[string]map[string]bool
+
+	locksMu sync.Mutex
+	locks   map[string]*questionnaireLock
+}
+
+// questionnaireLock is the lock of a questionnaire, dropped once nobody holds or waits for it.
+type questionnaireLock struct {
+	mu   sync.Mutex
+	refs int
 }
 
 func NewInMemoryRepo() Repository {
 	return &InMemoryRepository{
 		questionnaires: make(map[string]Questionnaire),
 		questions:      make(map[string][]Question),
+		bans:           make(map[string][]Ban),
+		index:          make(map[string]map[string]map[string]bool),
+		locks:          make(map[string]*questionnaireLock),
 	}
 }
 
@@ -23,9 +40,25 @@ func (r *InMemoryRepository) SaveQuestionnaire(q Questionnaire) error {
 	defer r.mu.Unlock()
 	r.questionnaires[q.ID] = q
 	r.questions[q.ID] = make([]Question, 0, 10)
+	r.index[q.ID] = make(map[string]map[string]bool)
 	return nil
 }
 
+func (r *InMemoryRepository) UpdateQuestionnaire(questionnaireID string, update func(q *Questionnaire) error) (Questionnaire, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	q, found := r.questionnaires[questionnaireID]
+	if !found {
+		return Questionnaire{}, fmt.Errorf("questionnaire %s not found", questionnaireID)
+	}
+	err := update(&q)
+	if err != nil {
+		return Questionnaire{}, err
+	}
+	r.questionnaires[questionnaireID] = q
+	return q, nil
+}
+
 func (r *InMemoryRepository) SaveQuestion(questionnaireID string, q Question) error {
 	_, found := r.questionnaires[questionnaireID]
 	if !found {
@@ -34,6 +67,13 @@ func (r *InMemoryRepository) SaveQuestion(questionnaireID string, q Question) er
 
 	r.mu.Lock()
 	r.questions[questionnaireID] = append(r.questions[questionnaireID], q)
+	terms := r.index[questionnaireID]
+	for _, t := range indexTerms(q) {
+		if terms[t] == nil {
+			terms[t] = make(map[string]bool)
+		}
+		terms[t][q.ID] = true
+	}
 	r.mu.Unlock()
 
 	return nil
@@ -66,7 +106,7 @@ func (r *InMemoryRepository) Vote(questionnaireID string, questionID string) (ui
 
 	r.mu.Lock()
 	for i, q := range r.questions[questionnaireID] {
-		if q.ID == questionID && !q.Metadata.Answered {
+		if q.ID == questionID && !q.Metadata.Answered && q.MergedInto == "" {
 			found = true
 			r.questions[questionnaireID][i].Metadata.Votes++
 			count = r.questions[questionnaireID][i].Metadata.Votes
@@ -102,10 +142,153 @@ func (r *InMemoryRepository) Answer(questionnaireID string, questionID string) e
 	return nil
 }
 
+func (r *InMemoryRepository) Tag(questionnaireID string, questionID string, tag string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	qs, found := r.questions[questionnaireID]
+	if !found {
+		return fmt.Errorf("questionnaire %s not found", questionnaireID)
+	}
+	for i, q := range qs {
+		if q.ID == questionID {
+			qs[i].Tag = tag
+			return nil
+		}
+	}
+	return fmt.Errorf("question %s not found", questionID)
+}
+
+func (r *InMemoryRepository) Merge(questionnaireID string, targetID string, mergedIDs []string, overlap int) (Question, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	qs, found := r.questions[questionnaireID]
+	if !found {
+		return Question{}, fmt.Errorf("questionnaire %s not found", questionnaireID)
+	}
+
+	idx := make(map[string]int, len(mergedIDs)+1)
+	for i, q := range qs {
+		if q.ID == targetID || slices.Contains(mergedIDs, q.ID) {
+			if q.MergedInto != "" {
+				return Question{}, fmt.Errorf("question %s was already merged", q.ID)
+			}
+			idx[q.ID] = i
+		}
+	}
+	for _, id := range append([]string{targetID}, mergedIDs...) {
+		if _, found := idx[id]; !found {
+			return Question{}, fmt.Errorf("question %s not found", id)
+		}
+	}
+
+	votes := -overlap
+	for _, id := range mergedIDs {
+		q := qs[idx[id]]
+		votes += int(q.Metadata.Votes)
+		qs[idx[id]].MergedInto = targetID
+		for _, t := range indexTerms(q) {
+			delete(r.index[questionnaireID][t], q.ID)
+		}
+	}
+
+	target := idx[targetID]
+	qs[target].Metadata.Votes = uint16(min(int(qs[target].Metadata.Votes)+max(votes, 0), math.MaxUint16))
+	return qs[target], nil
+}
+
+func (r *InMemoryRepository) Search(questionnaireID string, sq SearchQuery) ([]SearchResult, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	terms, found := r.index[questionnaireID]
+	if !found {
+		return nil, fmt.Errorf("questionnaire %s not found", questionnaireID)
+	}
+
+	queryTerms := sq.Terms()
+	if len(queryTerms) == 0 {
+		return []SearchResult{}, nil
+	}
+
+	df := make(map[string]int, len(queryTerms))
+	for _, t := range queryTerms {
+		df[t] = len(terms[t])
+	}
+
+	candidates := []Question{}
+	for _, q := range r.questions[questionnaireID] {
+		matchesAll := true
+		for _, t := range queryTerms {
+			if !terms[t][q.ID] {
+				matchesAll = false
+				break
+			}
+		}
+		if matchesAll {
+			candidates = append(candidates, q)
+		}
+	}
+
+	return rank(candidates, sq, df, len(r.questions[questionnaireID])), nil
+}
+
 func (r *InMemoryRepository) CountQuestionnaires() (int, error) {
 	return len(r.questionnaires), nil
 }
 
+func (r *InMemoryRepository) CountQuestionnairesByCreator(creator string) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	count := 0
+	for _, q := range r.questionnaires {
+		if q.Creator == creator {
+			count++
+		}
+	}
+	return count, nil
+}
+
 func (r *InMemoryRepository) CountQuestions(questionnaireID string) (int, error) {
 	return len(r.questions[questionnaireID]), nil
 }
+
+func (r *InMemoryRepository) SaveBan(questionnaireID string, b Ban) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, found := r.questionnaires[questionnaireID]
+	if !found {
+		return fmt.Errorf("questionnaire %s not found", questionnaireID)
+	}
+	r.bans[questionnaireID] = append(r.bans[questionnaireID], b)
+	return nil
+}
+
+func (r *InMemoryRepository) GetBans(questionnaireID string) ([]Ban, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.bans[questionnaireID], nil
+}
+
+func (r *InMemoryRepository) Lock(questionnaireID string) (func(), error) {
+	r.locksMu.Lock()
+	l, found := r.locks[questionnaireID]
+	if !found {
+		l = &questionnaireLock{}
+		r.locks[questionnaireID] = l
+	}
+	l.refs++
+	r.locksMu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		r.locksMu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(r.locks, questionnaireID)
+		}
+		r.locksMu.Unlock()
+	}, nil
+}
@@ -3,25 +3,49 @@ package questionnaire
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"math/rand/v2"
+	"strconv"
 	"time"
 
+	"github.com/germandv/ama/internal/uid"
 	"github.com/redis/go-redis/v9"
 )
 
+// questionRecord is how questions are stored, including the fields not exposed to clients.
+type questionRecord struct {
+	Question
+	Author             Participant `json:"author"`
+	PossibleDuplicates []string    `json:"possible_duplicates,omitempty"`
+}
+
+func encodeQuestion(q Question) ([]byte, error) {
+	return json.Marshal(questionRecord{Question: q, Author: q.Author, PossibleDuplicates: q.PossibleDuplicates})
+}
+
+func decodeQuestion(val []byte) (Question, error) {
+	rec := questionRecord{}
+	err := json.Unmarshal(val, &rec)
+	if err != nil {
+		return Question{}, err
+	}
+	q := rec.Question
+	q.Author = rec.Author
+	q.PossibleDuplicates = rec.PossibleDuplicates
+	return q, nil
+}
+
 type RedisRepository struct {
 	client *redis.Client
 	ttl    time.Duration
 }
 
-func NewRedisRepo(host string, port int, password string, ttl time.Duration) Repository {
+func NewRedisRepo(client *redis.Client, ttl time.Duration) Repository {
 	return &RedisRepository{
-		ttl: ttl,
-		client: redis.NewClient(&redis.Options{
-			Addr:     fmt.Sprintf("%s:%d", host, port),
-			Password: password,
-			DB:       0,
-		}),
+		ttl:    ttl,
+		client: client,
 	}
 }
 
@@ -31,7 +55,96 @@ func (r *RedisRepository) SaveQuestionnaire(q Questionnaire) error {
 	if err != nil {
 		return err
 	}
-	return r.client.Set(context.TODO(), key, val, r.ttl).Err()
+
+	err = r.client.Set(context.TODO(), key, val, r.ttl).Err()
+	if err != nil {
+		return err
+	}
+
+	if q.Creator == "" {
+		return nil
+	}
+
+	// Questionnaires of each creator are kept in a sorted set scored by their expiration,
+	// so expired ones can be dropped before counting.
+	creatorKey := fmt.Sprintf("QC:%s", q.Creator)
+	exp := time.Now().Add(r.ttl).Unix()
+	_, err = r.client.TxPipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(context.TODO(), creatorKey, redis.Z{Score: float64(exp), Member: q.ID})
+		pipe.Expire(context.TODO(), creatorKey, r.ttl)
+		return nil
+	})
+	return err
+}
+
+func (r *RedisRepository) CountQuestionnairesByCreator(creator string) (int, error) {
+	key := fmt.Sprintf("QC:%s", creator)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	var count *redis.IntCmd
+	_, err := r.client.TxPipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(context.TODO(), key, "-inf", now)
+		count = pipe.ZCard(context.TODO(), key)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(count.Val()), nil
+}
+
+func (r *RedisRepository) UpdateQuestionnaire(questionnaireID string, update func(q *Questionnaire) error) (Questionnaire, error) {
+	key := fmt.Sprintf("QA:%s", questionnaireID)
+	q := Questionnaire{}
+
+	err := r.transaction(func(tx *redis.Tx) error {
+		val, err := tx.Get(context.TODO(), key).Bytes()
+		if err != nil {
+			return err
+		}
+
+		q = Questionnaire{}
+		err = json.Unmarshal(val, &q)
+		if err != nil {
+			return err
+		}
+
+		err = update(&q)
+		if err != nil {
+			return err
+		}
+
+		val, err = json.Marshal(q)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+			pipe.SetXX(context.TODO(), key, val, redis.KeepTTL)
+			return nil
+		})
+		return err
+	}, key)
+	if err != nil {
+		return Questionnaire{}, err
+	}
+	return q, nil
+}
+
+// maxTxRetries is how many times a transaction is retried when the keys it watches change.
+const maxTxRetries = 10
+
+// transaction runs fn watching keys, retrying when another client changes them
+// before fn commits its writes with tx.TxPipelined. Retries back off a random
+// while so contending clients do not keep colliding.
+func (r *RedisRepository) transaction(fn func(tx *redis.Tx) error, keys ...string) error {
+	for i := range maxTxRetries {
+		err := r.client.Watch(context.TODO(), fn, keys...)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+		time.Sleep(rand.N(time.Duration(i+1) * time.Millisecond))
+	}
+	return fmt.Errorf("too much contention on %v", keys)
 }
 
 func (r *RedisRepository) GetQuestionnaire(questionnaireID string) (Questionnaire, error) {
@@ -52,48 +165,81 @@ func (r *RedisRepository) GetQuestionnaire(questionnaireID string) (Questionnair
 }
 
 func (r *RedisRepository) CountQuestionnaires() (int, error) {
-	keys, cursor, err := r.client.Scan(context.TODO(), 0, "QA:*", 100).Result()
-	if err != nil {
-		return 0, err
-	}
-	if cursor != 0 {
-		return 0, fmt.Errorf("more than 100 questionnaires")
+	// SCAN goes through the whole keyspace a page at a time, each page may have any number of matches.
+	count := 0
+	cursor := uint64(0)
+	for {
+		keys, next, err := r.client.Scan(context.TODO(), cursor, "QA:*", 1000).Result()
+		if err != nil {
+			return 0, err
+		}
+		count += len(keys)
+		if next == 0 {
+			return count, nil
+		}
+		cursor = next
 	}
-	return len(keys), nil
 }
 
 func (r *RedisRepository) SaveQuestion(questionnaireID string, q Question) error {
 	key := fmt.Sprintf("%s:%s", questionnaireID, q.ID)
-	val, err := json.Marshal(q)
+	val, err := encodeQuestion(q)
 	if err != nil {
 		return err
 	}
-	return r.client.Set(context.TODO(), key, val, r.ttl).Err()
+
+	// The IDs of the questions of each questionnaire are kept in a set, so they can be listed
+	// without scanning the keyspace. Each term of the questionnaire has a set with the IDs
+	// of the questions containing it.
+	listKey := fmt.Sprintf("QQ:%s", questionnaireID)
+	_, err = r.client.TxPipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+		pipe.Set(context.TODO(), key, val, r.ttl)
+		pipe.SAdd(context.TODO(), listKey, q.ID)
+		pipe.Expire(context.TODO(), listKey, r.ttl)
+		for _, t := range indexTerms(q) {
+			termKey := fmt.Sprintf("QI:%s:%s", questionnaireID, t)
+			pipe.SAdd(context.TODO(), termKey, q.ID)
+			pipe.Expire(context.TODO(), termKey, r.ttl)
+		}
+		return nil
+	})
+	return err
 }
 
 func (r *RedisRepository) GetQuestions(questionnaireID string) ([]Question, error) {
-	keyPattern := fmt.Sprintf("%s:*", questionnaireID)
-	keys, cursor, err := r.client.Scan(context.TODO(), 0, keyPattern, 100).Result()
+	ids, err := r.client.SMembers(context.TODO(), fmt.Sprintf("QQ:%s", questionnaireID)).Result()
 	if err != nil {
 		return nil, err
 	}
-	if cursor != 0 {
-		return nil, fmt.Errorf("more than 100 questions in questionnaire %s", questionnaireID)
+	return r.getQuestions(questionnaireID, ids)
+}
+
+// getQuestions fetches questions by ID, skipping the ones that expired.
+func (r *RedisRepository) getQuestions(questionnaireID string, ids []string) ([]Question, error) {
+	if len(ids) == 0 {
+		return []Question{}, nil
 	}
 
-	qs := make([]Question, 0, len(keys))
-	for _, key := range keys {
-		val, err := r.client.Get(context.TODO(), key).Bytes()
-		if err != nil {
-			return nil, err
-		}
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, fmt.Sprintf("%s:%s", questionnaireID, id))
+	}
+	vals, err := r.client.MGet(context.TODO(), keys...).Result()
+	if err != nil {
+		return nil, err
+	}
 
-		q := Question{}
-		err = json.Unmarshal(val, &q)
+	qs := make([]Question, 0, len(vals))
+	for _, val := range vals {
+		str, ok := val.(string)
+		if !ok {
+			// Expired, the sets of IDs outlive the questions in them.
+			continue
+		}
+		q, err := decodeQuestion([]byte(str))
 		if err != nil {
 			return nil, err
 		}
-
 		qs = append(qs, q)
 	}
 
@@ -101,67 +247,271 @@ func (r *RedisRepository) GetQuestions(questionnaireID string) ([]Question, erro
 }
 
 func (r *RedisRepository) CountQuestions(questionnaireID string) (int, error) {
-	keyPattern := fmt.Sprintf("%s:*", questionnaireID)
-	keys, cursor, err := r.client.Scan(context.TODO(), 0, keyPattern, 100).Result()
+	count, err := r.client.SCard(context.TODO(), fmt.Sprintf("QQ:%s", questionnaireID)).Result()
 	if err != nil {
 		return 0, err
 	}
-	if cursor != 0 {
-		return 0, fmt.Errorf("more than 100 questions in questionnaire %s", questionnaireID)
-	}
-	return len(keys), nil
+	return int(count), nil
 }
 
-func (r *RedisRepository) updateQuestion(key string, kind string) (Question, error) {
-	val, err := r.client.Get(context.TODO(), key).Bytes()
+// updateQuestion atomically applies update to the stored question, keeping its expiration.
+func (r *RedisRepository) updateQuestion(key string, update func(q *Question) error) (Question, error) {
+	q := Question{}
+	err := r.transaction(func(tx *redis.Tx) error {
+		val, err := tx.Get(context.TODO(), key).Bytes()
+		if err != nil {
+			return err
+		}
+
+		q, err = decodeQuestion(val)
+		if err != nil {
+			return err
+		}
+
+		err = update(&q)
+		if err != nil {
+			return err
+		}
+
+		val, err = encodeQuestion(q)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+			pipe.SetXX(context.TODO(), key, val, redis.KeepTTL)
+			return nil
+		})
+		return err
+	}, key)
 	if err != nil {
 		return Question{}, err
 	}
+	return q, nil
+}
 
-	q := Question{}
+// voteScript increments the votes of a question in place, votes come in bursts on the
+// same question and would keep failing a transaction watching it.
+var voteScript = redis.NewScript(`
+local val = redis.call("GET", KEYS[1])
+if not val then
+	return redis.error_reply("question " .. ARGV[1] .. " not found")
+end
+local q = cjson.decode(val)
+if q.merged_into and q.merged_into ~= "" then
+	return redis.error_reply("question " .. ARGV[1] .. " was merged into " .. q.merged_into)
+end
+q.metadata.votes = math.min(q.metadata.votes + 1, 65535)
+redis.call("SET", KEYS[1], cjson.encode(q), "KEEPTTL")
+return q.metadata.votes
+`)
 
-	err = json.Unmarshal(val, &q)
+func (r *RedisRepository) Vote(questionnaireID string, questionID string) (uint16, error) {
+	key := fmt.Sprintf("%s:%s", questionnaireID, questionID)
+	votes, err := voteScript.Run(context.TODO(), r.client, []string{key}, questionID).Int()
 	if err != nil {
-		return Question{}, err
+		return 0, err
 	}
+	return uint16(votes), nil
+}
 
-	if kind == "vote" {
-		q.Metadata.Votes++
-	} else if kind == "answer" {
+func (r *RedisRepository) Answer(questionnaireID string, questionID string) error {
+	key := fmt.Sprintf("%s:%s", questionnaireID, questionID)
+	_, err := r.updateQuestion(key, func(q *Question) error {
 		q.Metadata.Answered = true
-	} else {
-		return Question{}, fmt.Errorf("unknown update kind: %s", kind)
+		return nil
+	})
+	return err
+}
+
+func (r *RedisRepository) Tag(questionnaireID string, questionID string, tag string) error {
+	key := fmt.Sprintf("%s:%s", questionnaireID, questionID)
+	_, err := r.updateQuestion(key, func(q *Question) error {
+		q.Tag = tag
+		return nil
+	})
+	return err
+}
+
+func (r *RedisRepository) Merge(questionnaireID string, targetID string, mergedIDs []string, overlap int) (Question, error) {
+	ids := append([]string{targetID}, mergedIDs...)
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, fmt.Sprintf("%s:%s", questionnaireID, id))
 	}
 
-	val, err = json.Marshal(q)
+	target := Question{}
+	err := r.transaction(func(tx *redis.Tx) error {
+		vals, err := tx.MGet(context.TODO(), keys...).Result()
+		if err != nil {
+			return err
+		}
+
+		qs := make([]Question, 0, len(vals))
+		for i, val := range vals {
+			str, ok := val.(string)
+			if !ok {
+				return fmt.Errorf("question %s not found", ids[i])
+			}
+			q, err := decodeQuestion([]byte(str))
+			if err != nil {
+				return err
+			}
+			if q.MergedInto != "" {
+				return fmt.Errorf("question %s was already merged", q.ID)
+			}
+			qs = append(qs, q)
+		}
+
+		target = qs[0]
+		votes := -overlap
+		for i := range qs[1:] {
+			votes += int(qs[i+1].Metadata.Votes)
+			qs[i+1].MergedInto = targetID
+		}
+		target.Metadata.Votes = uint16(min(int(target.Metadata.Votes)+max(votes, 0), math.MaxUint16))
+		qs[0] = target
+
+		_, err = tx.TxPipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+			for i, q := range qs {
+				val, err := encodeQuestion(q)
+				if err != nil {
+					return err
+				}
+				pipe.SetXX(context.TODO(), keys[i], val, redis.KeepTTL)
+				if i == 0 {
+					continue
+				}
+				for _, t := range indexTerms(q) {
+					pipe.SRem(context.TODO(), fmt.Sprintf("QI:%s:%s", questionnaireID, t), q.ID)
+				}
+			}
+			return nil
+		})
+		return err
+	}, keys...)
 	if err != nil {
 		return Question{}, err
 	}
+	return target, nil
+}
+
+func (r *RedisRepository) Search(questionnaireID string, sq SearchQuery) ([]SearchResult, error) {
+	terms := sq.Terms()
+	if len(terms) == 0 {
+		return []SearchResult{}, nil
+	}
+
+	termKeys := make([]string, 0, len(terms))
+	for _, t := range terms {
+		termKeys = append(termKeys, fmt.Sprintf("QI:%s:%s", questionnaireID, t))
+	}
 
-	exp, err := r.client.ExpireTime(context.Background(), key).Result()
+	var ids *redis.StringSliceCmd
+	dfs := make([]*redis.IntCmd, 0, len(termKeys))
+	_, err := r.client.Pipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+		ids = pipe.SInter(context.TODO(), termKeys...)
+		for _, k := range termKeys {
+			dfs = append(dfs, pipe.SCard(context.TODO(), k))
+		}
+		return nil
+	})
 	if err != nil {
-		return Question{}, err
+		return nil, err
+	}
+	if len(ids.Val()) == 0 {
+		return []SearchResult{}, nil
 	}
 
-	err = r.client.Set(context.TODO(), key, val, exp).Err()
+	df := make(map[string]int, len(terms))
+	for i, t := range terms {
+		df[t] = int(dfs[i].Val())
+	}
+
+	candidates, err := r.getQuestions(questionnaireID, ids.Val())
 	if err != nil {
-		return Question{}, err
+		return nil, err
 	}
 
-	return q, nil
+	total, err := r.CountQuestions(questionnaireID)
+	if err != nil {
+		return nil, err
+	}
+
+	return rank(candidates, sq, df, total), nil
 }
 
-func (r *RedisRepository) Vote(questionnaireID string, questionID string) (uint16, error) {
-	key := fmt.Sprintf("%s:%s", questionnaireID, questionID)
-	updatedQ, err := r.updateQuestion(key, "vote")
+func (r *RedisRepository) SaveBan(questionnaireID string, b Ban) error {
+	key := fmt.Sprintf("BAN:%s", questionnaireID)
+	val, err := json.Marshal(b)
 	if err != nil {
-		return 0, err
+		return err
 	}
-	return updatedQ.Metadata.Votes, nil
-}
 
-func (r *RedisRepository) Answer(questionnaireID string, questionID string) error {
-	key := fmt.Sprintf("%s:%s", questionnaireID, questionID)
-	_, err := r.updateQuestion(key, "answer")
+	_, err = r.client.TxPipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+		pipe.RPush(context.TODO(), key, val)
+		pipe.Expire(context.TODO(), key, r.ttl)
+		return nil
+	})
 	return err
 }
+
+func (r *RedisRepository) GetBans(questionnaireID string) ([]Ban, error) {
+	key := fmt.Sprintf("BAN:%s", questionnaireID)
+	vals, err := r.client.LRange(context.TODO(), key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	bans := make([]Ban, 0, len(vals))
+	for _, val := range vals {
+		b := Ban{}
+		err = json.Unmarshal([]byte(val), &b)
+		if err != nil {
+			return nil, err
+		}
+		bans = append(bans, b)
+	}
+
+	return bans, nil
+}
+
+const (
+	// lockTTL bounds how long a lock outlives a replica that died holding it.
+	lockTTL = 10 * time.Second
+	// lockTimeout is how long Lock waits for a lock held by someone else.
+	lockTimeout = 5 * time.Second
+)
+
+// unlockScript deletes a lock only if it still holds the token of its owner,
+// it could have expired and been taken by someone else.
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func (r *RedisRepository) Lock(questionnaireID string) (func(), error) {
+	key := fmt.Sprintf("LOCK:%s", questionnaireID)
+	token := uid.Generate(false, 16)
+
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		acquired, err := r.client.SetNX(context.TODO(), key, token, lockTTL).Result()
+		if err != nil {
+			return nil, err
+		}
+		if acquired {
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for the lock of questionnaire %s", questionnaireID)
+		}
+		time.Sleep(5*time.Millisecond + rand.N(10*time.Millisecond))
+	}
+
+	return func() {
+		// Failing to unlock is not fatal, the lock expires.
+		_ = unlockScript.Run(context.TODO(), r.client, []string{key}, token).Err()
+	}, nil
+}
@@ -0,0 +1,119 @@
+package questionnaire
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// SearchQuery is a parsed search: words that must all appear in a question,
+// and phrases, in double quotes, whose words must appear in sequence.
+type SearchQuery struct {
+	Words   []string
+	Phrases [][]string
+}
+
+// ParseQuery splits a search into words and "quoted phrases",
+// normalized the same way as the indexed questions.
+func ParseQuery(query string) SearchQuery {
+	sq := SearchQuery{}
+	for i, part := range strings.Split(norm.NFC.String(query), `"`) {
+		words := normalizeTokens(part)
+		// Odd parts are between quotes, an unclosed quote runs until the end.
+		if i%2 == 1 && len(words) > 1 {
+			sq.Phrases = append(sq.Phrases, words)
+		} else {
+			sq.Words = append(sq.Words, words...)
+		}
+	}
+	return sq
+}
+
+// Terms returns every distinct word of the query, phrases included.
+func (sq SearchQuery) Terms() []string {
+	terms := slices.Clone(sq.Words)
+	for _, p := range sq.Phrases {
+		terms = append(terms, p...)
+	}
+	slices.Sort(terms)
+	return slices.Compact(terms)
+}
+
+// SearchResult is a question matching a search, the higher the score the better the match.
+type SearchResult struct {
+	Question Question `json:"question"`
+	Score    float64  `json:"score"`
+}
+
+// indexTerms returns the distinct words a question is indexed by.
+func indexTerms(q Question) []string {
+	terms := normalizeTokens(q.Question)
+	slices.Sort(terms)
+	return slices.Compact(terms)
+}
+
+// rank scores the candidate questions of a search, which contain every term of the query,
+// and sorts them best first. Questions missing a phrase are left out.
+// Terms are weighted by how rare they are among the total questions, df being the
+// number of questions each term appears in.
+func rank(candidates []Question, sq SearchQuery, df map[string]int, total int) []SearchResult {
+	results := []SearchResult{}
+	for _, q := range candidates {
+		words := normalizeTokens(q.Question)
+
+		tf := make(map[string]int, len(words))
+		for _, w := range words {
+			tf[w]++
+		}
+
+		score := 0.0
+		for _, t := range sq.Terms() {
+			if tf[t] == 0 {
+				continue
+			}
+			idf := math.Log(1 + float64(total)/float64(max(df[t], 1)))
+			score += (1 + math.Log(float64(tf[t]))) * idf
+		}
+
+		matchesPhrases := true
+		for _, p := range sq.Phrases {
+			if !containsPhrase(words, p) {
+				matchesPhrases = false
+				break
+			}
+			// Phrases count twice, they are a more precise match than their words.
+			for _, t := range p {
+				score += math.Log(1 + float64(total)/float64(max(df[t], 1)))
+			}
+		}
+		if !matchesPhrases || score == 0 {
+			continue
+		}
+
+		// Shorter questions are more about the terms than long ones mentioning them.
+		score /= math.Sqrt(float64(len(words)))
+		results = append(results, SearchResult{Question: q, Score: score})
+	}
+
+	slices.SortFunc(results, func(a, b SearchResult) int {
+		return cmp.Or(
+			cmp.Compare(b.Score, a.Score),
+			cmp.Compare(b.Question.Metadata.Votes, a.Question.Metadata.Votes),
+			a.Question.CreatedAt.Compare(b.Question.CreatedAt),
+		)
+	})
+	return results
+}
+
+// containsPhrase tells if words contains the words of phrase in sequence.
+func containsPhrase(words []string, phrase []string) bool {
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		if slices.Equal(words[i:i+len(phrase)], phrase) {
+			return true
+		}
+	}
+	return false
+}
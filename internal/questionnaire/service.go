@@ -1,8 +1,11 @@
 package questionnaire
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -10,39 +13,102 @@ import (
 )
 
 type IService interface {
-	Create(title string) (Questionnaire, error)
-	Ask(questionnaireID string, text string) (Question, error)
-	Get(questionnaireID string) ([]Question, error)
+	Create(title string, settings Settings, creator string) (Questionnaire, error)
+	UpdateSettings(questionnaireID string, patch SettingsPatch) (Questionnaire, error)
+	QuestionLimit(questionnaireID string) (int, error)
+	Ask(questionnaireID string, req AskRequest) (Question, error)
+	Get(questionnaireID string, viewer Participant) ([]Question, error)
 	GetMeta(questionnaireID string) (Questionnaire, error)
 	CountQuestionnaires() (int, error)
+	CountQuestionnairesByCreator(creator string) (int, error)
 	CountQuestions(questionnaireID string) (int, error)
-	Vote(questionnaireID string, questionID string, voterID string) (uint16, error)
-	Answer(questionnaireID string, questionID string) error
+	Vote(questionnaireID string, questionID string, voter Participant) (uint16, error)
+	Answer(questionnaireID string, questionID string) (unspotlighted bool, err error)
+	Tag(questionnaireID string, questionID string, tag string) error
+	Pin(questionnaireID string, questionID string) error
+	Spotlight(questionnaireID string, questionID string) (Question, error)
+	Ban(questionnaireID string, questionID string, shadow bool) error
+	Merge(questionnaireID string, targetID string, mergedIDs []string) (MergeResult, error)
+	Suggest(questionnaireID string, text string, viewer Participant) ([]Match, error)
+	Search(questionnaireID string, query string, viewer Participant) ([]SearchResult, error)
 }
 
 // ballot keeps track of who voted which questions.
 type ballot struct {
 	id         string
 	expiration time.Time
-	voters     map[string]bool
+	// voters has everyone who voted the question, true if their vote was counted,
+	// false if it was not (e.g. shadow banned or discounted votes).
+	voters map[string]bool
+}
+
+// Config holds the server-wide settings of the Service.
+type Config struct {
+	TTL              time.Duration
+	BallotGCInterval time.Duration
+	// MaxQuestions is the default cap on questions per questionnaire.
+	MaxQuestions int
+	// MaxQuestionsCeiling is the highest cap a host can set for their questionnaire.
+	MaxQuestionsCeiling int
+	// Analyzer watches votes for anomalies, it is optional.
+	Analyzer *Analyzer
+	// MaxQuestionLength is the most characters a question can have.
+	MaxQuestionLength int
+	// Blocklist are the words filtered out of every questionnaire.
+	Blocklist []string
+}
+
+// AskRequest is what a participant submits when asking a question.
+type AskRequest struct {
+	Text   string
+	Author Participant
+	// Force asks even if similar questions exist.
+	Force bool
+	// Tag is optional, it must be one of the questionnaire's tags.
+	Tag string
+	// Panelist is optional, it must be one of the questionnaire's panelists.
+	Panelist string
+}
+
+// SlowModeError is returned when a participant asks while slow mode prevents it.
+type SlowModeError struct {
+	// RetryAfter is how long until the participant can ask again,
+	// 0 if it depends on their questions being answered.
+	RetryAfter time.Duration
+	Reason     string
+}
+
+func (e *SlowModeError) Error() string {
+	return e.Reason
 }
 
 type Service struct {
 	repo    Repository
 	mu      sync.Mutex
-	ttl     time.Duration
+	cfg     Config
 	logger  *slog.Logger
 	ballots map[string]ballot
+	index   *Index
+	// pending counts the votes being saved, by question ID.
+	pending map[string]int
+	// votesSaved is signaled whenever a vote is done being saved.
+	votesSaved *sync.Cond
+	// merging has the IDs of the questions being merged, which cannot be voted meanwhile.
+	merging map[string]bool
 }
 
-func NewService(repo Repository, ttl time.Duration, logger *slog.Logger) IService {
+func NewService(repo Repository, cfg Config, logger *slog.Logger) IService {
 	svc := &Service{
 		repo:    repo,
 		mu:      sync.Mutex{},
-		ttl:     ttl,
+		cfg:     cfg,
 		logger:  logger,
 		ballots: make(map[string]ballot),
+		index:   NewIndex(cfg.TTL, cfg.BallotGCInterval),
+		pending: make(map[string]int),
+		merging: make(map[string]bool),
 	}
+	svc.votesSaved = sync.NewCond(&svc.mu)
 
 	go svc.gcExpiredBallots()
 	return svc
@@ -51,13 +117,13 @@ func NewService(repo Repository, ttl time.Duration, logger *slog.Logger) IServic
 func (s *Service) newBallot(id string) ballot {
 	return ballot{
 		id:         id,
-		expiration: time.Now().Add(s.ttl),
+		expiration: time.Now().Add(s.cfg.TTL),
 		voters:     make(map[string]bool),
 	}
 }
 
 func (s *Service) gcExpiredBallots() {
-	for range time.Tick(10 * time.Minute) {
+	for range time.Tick(s.cfg.BallotGCInterval) {
 		now := time.Now()
 		s.logger.Debug("started gcExpiredBallots", "ballots", len(s.ballots))
 		for id, ballot := range s.ballots {
@@ -69,60 +135,701 @@ func (s *Service) gcExpiredBallots() {
 	}
 }
 
-func (s *Service) Vote(questionnaireID string, questionID string, voterID string) (uint16, error) {
+func (s *Service) Vote(questionnaireID string, questionID string, voter Participant) (uint16, error) {
+	bans, err := s.repo.GetBans(questionnaireID)
+	if err != nil {
+		return 0, err
+	}
+	ban, banned := banFor(bans, voter)
+	if banned && !ban.Shadow {
+		return 0, ErrBanned
+	}
+
+	voterID := voter.Voter
 	s.mu.Lock()
 
+	if s.merging[questionID] {
+		s.mu.Unlock()
+		return 0, fmt.Errorf("question %s is being merged, try again", questionID)
+	}
+
 	_, found := s.ballots[questionID]
 	if !found {
 		s.ballots[questionID] = s.newBallot(questionID)
 	}
 
-	hasVoted, found := s.ballots[questionID].voters[voterID]
-	if !found || !hasVoted {
-		s.ballots[questionID].voters[voterID] = true
-		s.mu.Unlock()
-	} else {
+	_, hasVoted := s.ballots[questionID].voters[voterID]
+	if hasVoted {
 		s.mu.Unlock()
 		return 0, fmt.Errorf("%s already voted %s", voterID, questionID)
 	}
+	s.ballots[questionID].voters[voterID] = false
+	s.pending[questionID]++
+	s.mu.Unlock()
+	defer s.voteSaved(questionID)
 
-	return s.repo.Vote(questionnaireID, questionID)
+	if banned {
+		// Shadow banned votes look like they went through, but are not counted.
+		return s.votes(questionnaireID, questionID)
+	}
+
+	if s.cfg.Analyzer != nil && s.cfg.Analyzer.Observe(questionnaireID, questionID, voter) {
+		meta, err := s.repo.GetQuestionnaire(questionnaireID)
+		if err != nil {
+			s.forgetVote(questionID, voterID)
+			return 0, err
+		}
+		if meta.Settings.DiscountFlaggedVotes {
+			return s.votes(questionnaireID, questionID)
+		}
+	}
+
+	votes, err := s.repo.Vote(questionnaireID, questionID)
+	if err != nil {
+		s.forgetVote(questionID, voterID)
+		return 0, err
+	}
+	s.index.Update(questionnaireID, questionID, func(q *Question) {
+		q.Metadata.Votes = votes
+	})
+
+	s.mu.Lock()
+	if b, found := s.ballots[questionID]; found {
+		b.voters[voterID] = true
+	}
+	s.mu.Unlock()
+	return votes, nil
+}
+
+// voteSaved signals that a vote is done being saved, whether it went through or not.
+func (s *Service) voteSaved(questionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[questionID]--
+	if s.pending[questionID] == 0 {
+		delete(s.pending, questionID)
+	}
+	s.votesSaved.Broadcast()
 }
 
-func (s *Service) Create(title string) (Questionnaire, error) {
-	q := NewQuestionnaire(title)
-	err := s.repo.SaveQuestionnaire(q)
+// forgetVote takes a voter out of a ballot when their vote failed, so they can vote again.
+func (s *Service) forgetVote(questionID string, voterID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b, found := s.ballots[questionID]; found {
+		delete(b.voters, voterID)
+	}
+}
+
+// votes returns the current vote count of a question.
+func (s *Service) votes(questionnaireID string, questionID string) (uint16, error) {
+	q, err := s.question(questionnaireID, questionID)
+	if err != nil {
+		return 0, err
+	}
+	return q.Metadata.Votes, nil
+}
+
+// question returns a single question of a questionnaire.
+func (s *Service) question(questionnaireID string, questionID string) (Question, error) {
+	qs, err := s.repo.GetQuestions(questionnaireID)
+	if err != nil {
+		return Question{}, err
+	}
+	for _, q := range qs {
+		if q.ID == questionID {
+			return q, nil
+		}
+	}
+	return Question{}, fmt.Errorf("question %s not found", questionID)
+}
+
+func (s *Service) Create(title string, settings Settings, creator string) (Questionnaire, error) {
+	err := s.validateSettings(settings)
+	if err != nil {
+		return Questionnaire{}, err
+	}
+
+	q := NewQuestionnaire(title, settings, creator)
+	err = s.repo.SaveQuestionnaire(q)
 	if err != nil {
 		return Questionnaire{}, err
 	}
 	return q, nil
 }
 
-func (s *Service) Ask(questionnaireID string, text string) (Question, error) {
-	q := NewQuestion(uid.Generate(false, 16), questionnaireID, text)
-	err := s.repo.SaveQuestion(questionnaireID, q)
+// UpdateSettings applies the patch to the settings of the questionnaire.
+func (s *Service) UpdateSettings(questionnaireID string, patch SettingsPatch) (Questionnaire, error) {
+	return s.repo.UpdateQuestionnaire(questionnaireID, func(q *Questionnaire) error {
+		settings := patch.Apply(q.Settings)
+		err := s.validateSettings(settings)
+		if err != nil {
+			return err
+		}
+		q.Settings = settings
+		return nil
+	})
+}
+
+func (s *Service) validateSettings(settings Settings) error {
+	if settings.MaxQuestions < 0 || settings.MaxQuestions > s.cfg.MaxQuestionsCeiling {
+		return fmt.Errorf("max_questions must be between 1 and %d", s.cfg.MaxQuestionsCeiling)
+	}
+	if settings.SlowMode.CooldownSeconds < 0 {
+		return errors.New("slow_mode.cooldown_seconds cannot be negative")
+	}
+	if settings.SlowMode.MaxOpenQuestions < 0 {
+		return errors.New("slow_mode.max_open_questions cannot be negative")
+	}
+	if settings.Filter.MaxLength < 0 || settings.Filter.MaxLength > s.cfg.MaxQuestionLength {
+		return fmt.Errorf("filter.max_length must be between 1 and %d", s.cfg.MaxQuestionLength)
+	}
+	if settings.Filter.MinLength < 0 || settings.Filter.MinLength > s.maxQuestionLength(settings.Filter) {
+		return errors.New("filter.min_length must be between 1 and filter.max_length")
+	}
+	err := ValidateBlocklist(settings.Filter.Blocklist)
+	if err != nil {
+		return fmt.Errorf("filter.blocklist: %w", err)
+	}
+	switch settings.Duplicates.Mode {
+	case "", DuplicatesFlag, DuplicatesSuggest, DuplicatesOff:
+	default:
+		return fmt.Errorf("duplicates.mode must be %q, %q or %q", DuplicatesFlag, DuplicatesSuggest, DuplicatesOff)
+	}
+	if settings.Duplicates.Threshold < 0 || settings.Duplicates.Threshold > 1 {
+		return errors.New("duplicates.threshold must be between 0 and 1")
+	}
+	err = validateNames("tags", settings.Tags, maxTags)
+	if err != nil {
+		return err
+	}
+	err = validateNames("panelists", settings.Panelists, maxPanelists)
+	if err != nil {
+		return err
+	}
+	switch settings.Filter.BlockMode {
+	case "", BlockModeReplace, BlockModeReject:
+	default:
+		return fmt.Errorf("filter.block_mode must be %q or %q", BlockModeReplace, BlockModeReject)
+	}
+	return nil
+}
+
+const (
+	maxTags       = 20
+	maxPanelists  = 10
+	maxNameLength = 32
+)
+
+// validateNames checks a list of names set by the host, such as tags or panelists.
+func validateNames(field string, names []string, maxNames int) error {
+	if len(names) > maxNames {
+		return fmt.Errorf("there can be at most %d %s", maxNames, field)
+	}
+	for i, name := range names {
+		if name == "" || len(name) > maxNameLength || strings.TrimSpace(name) != name {
+			return fmt.Errorf("%s must have between 1 and %d characters, without surrounding spaces", field, maxNameLength)
+		}
+		if slices.Contains(names[:i], name) {
+			return fmt.Errorf("%s must be unique, %q is repeated", field, name)
+		}
+	}
+	return nil
+}
+
+func (s *Service) maxQuestionLength(settings FilterSettings) int {
+	if settings.MaxLength > 0 {
+		return settings.MaxLength
+	}
+	return s.cfg.MaxQuestionLength
+}
+
+// contentFilter builds the pipeline that new questions go through.
+func (s *Service) contentFilter(settings FilterSettings) Filter {
+	pipeline := Pipeline{Normalize(), StripControl()}
+	if settings.StripURLs {
+		pipeline = append(pipeline, StripURLs())
+	}
+
+	blocklist := append(slices.Clone(s.cfg.Blocklist), settings.Blocklist...)
+	return append(
+		pipeline,
+		Blocklist(blocklist, settings.BlockMode),
+		LengthLimit(max(settings.MinLength, 1), s.maxQuestionLength(settings)),
+	)
+}
+
+// QuestionLimit returns how many questions the questionnaire can hold.
+func (s *Service) QuestionLimit(questionnaireID string) (int, error) {
+	q, err := s.repo.GetQuestionnaire(questionnaireID)
+	if err != nil {
+		return 0, err
+	}
+	if q.Settings.MaxQuestions > 0 {
+		return q.Settings.MaxQuestions, nil
+	}
+	return s.cfg.MaxQuestions, nil
+}
+
+func (s *Service) Ask(questionnaireID string, req AskRequest) (Question, error) {
+	author := req.Author
+
+	meta, err := s.repo.GetQuestionnaire(questionnaireID)
 	if err != nil {
 		return Question{}, err
 	}
+
+	bans, err := s.repo.GetBans(questionnaireID)
+	if err != nil {
+		return Question{}, err
+	}
+	ban, banned := banFor(bans, author)
+	if banned && !ban.Shadow {
+		return Question{}, ErrBanned
+	}
+
+	err = checkTag(meta, req.Tag)
+	if err != nil {
+		return Question{}, err
+	}
+	if req.Panelist != "" && !slices.Contains(meta.Settings.Panelists, req.Panelist) {
+		return Question{}, fmt.Errorf("unknown panelist %q", req.Panelist)
+	}
+
+	text, err := s.contentFilter(meta.Settings.Filter).Apply(req.Text)
+	if err != nil {
+		return Question{}, err
+	}
+
+	// Checking slow mode and saving must be atomic, or a burst of questions would get through.
+	unlock, err := s.repo.Lock(questionnaireID)
+	if err != nil {
+		return Question{}, err
+	}
+	defer unlock()
+
+	qs, err := s.repo.GetQuestions(questionnaireID)
+	if err != nil {
+		return Question{}, err
+	}
+
+	err = checkSlowMode(meta, qs, author)
+	if err != nil {
+		return Question{}, err
+	}
+
+	// Only questions the asker can see, they would not be able to vote on the others.
+	duplicates, err := checkDuplicates(meta, visible(qs, bans, author), text, req)
+	if err != nil {
+		return Question{}, err
+	}
+
+	q := NewQuestion(uid.Generate(false, 16), questionnaireID, text, author)
+	q.PossibleDuplicates = duplicates
+	q.Tag = req.Tag
+	q.Panelist = req.Panelist
+	err = s.repo.SaveQuestion(questionnaireID, q)
+	if err != nil {
+		return Question{}, err
+	}
+	s.index.Add(questionnaireID, q)
+	q.Shadowed = banned
 	return q, nil
 }
 
-func (s *Service) Get(questionnaireID string) ([]Question, error) {
-	return s.repo.GetQuestions(questionnaireID)
+// checkDuplicates looks for questions similar to text. Depending on the questionnaire's
+// settings, it rejects the question with a DuplicateError or returns the IDs of the
+// duplicates so the question is flagged.
+func checkDuplicates(meta Questionnaire, qs []Question, text string, req AskRequest) ([]string, error) {
+	settings := meta.Settings.Duplicates
+	if settings.Mode == DuplicatesOff {
+		return nil, nil
+	}
+
+	threshold := settings.Threshold
+	if threshold == 0 {
+		threshold = defaultDuplicateThreshold
+	}
+
+	matches := findDuplicates(qs, text, threshold)
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	if settings.Mode == DuplicatesSuggest && !req.Force {
+		return nil, &DuplicateError{Matches: matches}
+	}
+
+	ids := make([]string, 0, len(matches))
+	for _, m := range matches {
+		ids = append(ids, m.ID)
+	}
+	return ids, nil
+}
+
+func checkSlowMode(meta Questionnaire, qs []Question, author Participant) error {
+	slowMode := meta.Settings.SlowMode
+	if slowMode.CooldownSeconds == 0 && slowMode.MaxOpenQuestions == 0 {
+		return nil
+	}
+
+	open := 0
+	var last time.Time
+	for _, q := range qs {
+		if q.Author.Key() != author.Key() {
+			continue
+		}
+		if !q.Metadata.Answered && q.MergedInto == "" {
+			open++
+		}
+		if q.CreatedAt.After(last) {
+			last = q.CreatedAt
+		}
+	}
+
+	cooldown := time.Duration(slowMode.CooldownSeconds) * time.Second
+	if wait := time.Until(last.Add(cooldown)); wait > 0 {
+		return &SlowModeError{
+			RetryAfter: wait,
+			Reason:     fmt.Sprintf("slow mode is on, you can ask again in %s", wait.Round(time.Second)),
+		}
+	}
+
+	if slowMode.MaxOpenQuestions > 0 && open >= slowMode.MaxOpenQuestions {
+		return &SlowModeError{
+			Reason: fmt.Sprintf("slow mode is on, you can ask again once one of your %d open questions is answered", open),
+		}
+	}
+
+	return nil
+}
+
+// Get returns the questions of a questionnaire as seen by viewer, leaving out merged questions.
+// Questions of shadow banned participants are only visible to themselves.
+func (s *Service) Get(questionnaireID string, viewer Participant) ([]Question, error) {
+	qs, err := s.repo.GetQuestions(questionnaireID)
+	if err != nil {
+		return nil, err
+	}
+
+	bans, err := s.repo.GetBans(questionnaireID)
+	if err != nil {
+		return nil, err
+	}
+
+	return visible(qs, bans, viewer), nil
+}
+
+// visible returns the questions viewer can see.
+func visible(qs []Question, bans []Ban, viewer Participant) []Question {
+	vs := make([]Question, 0, len(qs))
+	for _, q := range qs {
+		if q, ok := visibleTo(q, bans, viewer); ok {
+			vs = append(vs, q)
+		}
+	}
+	return vs
+}
+
+// visibleTo tells if viewer can see the question, setting Shadowed if its author is shadow banned.
+func visibleTo(q Question, bans []Ban, viewer Participant) (Question, bool) {
+	if q.MergedInto != "" {
+		return q, false
+	}
+	ban, banned := banFor(bans, q.Author)
+	if banned && ban.Shadow {
+		q.Shadowed = true
+		return q, q.Author.Key() == viewer.Key()
+	}
+	return q, true
+}
+
+// Search finds the questions visible to viewer matching the query, best matches first.
+// Words in the query must all appear in a question, "quoted phrases" must appear as is.
+func (s *Service) Search(questionnaireID string, query string, viewer Participant) ([]SearchResult, error) {
+	results, err := s.repo.Search(questionnaireID, ParseQuery(query))
+	if err != nil {
+		return nil, err
+	}
+
+	bans, err := s.repo.GetBans(questionnaireID)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := make([]SearchResult, 0, len(results))
+	for _, res := range results {
+		if q, ok := visibleTo(res.Question, bans, viewer); ok {
+			res.Question = q
+			visible = append(visible, res)
+		}
+	}
+	return visible, nil
 }
 
 func (s *Service) GetMeta(questionnaireID string) (Questionnaire, error) {
 	return s.repo.GetQuestionnaire(questionnaireID)
 }
 
-func (s *Service) Answer(questionnaireID string, questionID string) error {
-	return s.repo.Answer(questionnaireID, questionID)
+// Answer marks a question as answered, and clears the spotlight if it was on it.
+// It returns whether it cleared the spotlight.
+func (s *Service) Answer(questionnaireID string, questionID string) (bool, error) {
+	err := s.repo.Answer(questionnaireID, questionID)
+	if err != nil {
+		return false, err
+	}
+	s.index.Update(questionnaireID, questionID, func(q *Question) {
+		q.Metadata.Answered = true
+	})
+
+	// Once answered, the question is no longer being answered.
+	unspotlighted := false
+	_, err = s.repo.UpdateQuestionnaire(questionnaireID, func(meta *Questionnaire) error {
+		unspotlighted = meta.Spotlight == questionID
+		if unspotlighted {
+			meta.Spotlight = ""
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return unspotlighted, nil
+}
+
+// Tag files a question under one of the questionnaire's tags, an empty tag removes it.
+func (s *Service) Tag(questionnaireID string, questionID string, tag string) error {
+	meta, err := s.repo.GetQuestionnaire(questionnaireID)
+	if err != nil {
+		return err
+	}
+
+	err = checkTag(meta, tag)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.Tag(questionnaireID, questionID, tag)
+}
+
+// Pin pins a question to the top of the questionnaire, an empty questionID unpins it.
+func (s *Service) Pin(questionnaireID string, questionID string) error {
+	_, err := s.highlight(questionnaireID, questionID, func(meta *Questionnaire) {
+		meta.Pinned = questionID
+	})
+	return err
+}
+
+// Spotlight marks a question as the one being answered right now, an empty questionID
+// clears the spotlight. It returns the question in the spotlight.
+func (s *Service) Spotlight(questionnaireID string, questionID string) (Question, error) {
+	return s.highlight(questionnaireID, questionID, func(meta *Questionnaire) {
+		meta.Spotlight = questionID
+	})
+}
+
+// highlight checks the question can be highlighted and stores it on the questionnaire with set.
+func (s *Service) highlight(questionnaireID string, questionID string, set func(meta *Questionnaire)) (Question, error) {
+	q := Question{}
+	if questionID != "" {
+		var err error
+		q, err = s.question(questionnaireID, questionID)
+		if err != nil {
+			return Question{}, err
+		}
+		if q.MergedInto != "" {
+			return Question{}, fmt.Errorf("question %s was merged into %s", q.ID, q.MergedInto)
+		}
+	}
+
+	_, err := s.repo.UpdateQuestionnaire(questionnaireID, func(meta *Questionnaire) error {
+		set(meta)
+		return nil
+	})
+	if err != nil {
+		return Question{}, err
+	}
+	return q, nil
+}
+
+func checkTag(meta Questionnaire, tag string) error {
+	if tag != "" && !slices.Contains(meta.Settings.Tags, tag) {
+		return fmt.Errorf("unknown tag %q", tag)
+	}
+	return nil
+}
+
+// Ban bans the author of a question from the questionnaire.
+func (s *Service) Ban(questionnaireID string, questionID string, shadow bool) error {
+	qs, err := s.repo.GetQuestions(questionnaireID)
+	if err != nil {
+		return err
+	}
+
+	for _, q := range qs {
+		if q.ID == questionID {
+			ban := NewBan(q.Author, shadow)
+			err = s.repo.SaveBan(questionnaireID, ban)
+			if err != nil {
+				return err
+			}
+			s.index.Ban(questionnaireID, ban)
+			return nil
+		}
+	}
+	return fmt.Errorf("question %s not found", questionID)
+}
+
+// MergeResult is the outcome of merging questions.
+type MergeResult struct {
+	// Question is the target, with the votes of the merged questions.
+	Question Question
+	// Unpinned and Unspotlighted tell if a merged question was pinned or in the spotlight,
+	// which were cleared as merged questions are hidden.
+	Unpinned      bool
+	Unspotlighted bool
+}
+
+// Merge merges questions into a target question. The target gets the votes of the merged
+// questions, counting once the voters who voted more than one of them, and the merged
+// questions are hidden.
+// A merged question that was pinned or in the spotlight is cleared from there.
+func (s *Service) Merge(questionnaireID string, targetID string, mergedIDs []string) (MergeResult, error) {
+	if len(mergedIDs) == 0 {
+		return MergeResult{}, errors.New("no questions to merge")
+	}
+	if slices.Contains(mergedIDs, targetID) {
+		return MergeResult{}, errors.New("cannot merge a question into itself")
+	}
+
+	sorted := slices.Clone(mergedIDs)
+	slices.Sort(sorted)
+	if len(slices.Compact(sorted)) != len(mergedIDs) {
+		return MergeResult{}, errors.New("cannot merge a question more than once")
+	}
+
+	ids := append([]string{targetID}, mergedIDs...)
+	s.mu.Lock()
+	for _, id := range ids {
+		if s.merging[id] {
+			s.mu.Unlock()
+			return MergeResult{}, fmt.Errorf("question %s is already being merged", id)
+		}
+	}
+	// New votes on the questions are rejected until the merge is done, and the ones
+	// being saved are waited for, so that the overlap below holds when the repository
+	// adds up the votes. Ballots are kept by each replica, so votes through other
+	// replicas can still be counted twice.
+	for _, id := range ids {
+		s.merging[id] = true
+	}
+	defer func() {
+		s.mu.Lock()
+		for _, id := range ids {
+			delete(s.merging, id)
+		}
+		s.mu.Unlock()
+	}()
+	for slices.ContainsFunc(ids, func(id string) bool { return s.pending[id] > 0 }) {
+		s.votesSaved.Wait()
+	}
+
+	// Voters who had their vote counted in more than one of the questions
+	// only count once in the target.
+	counted := map[string]bool{}
+	for voter, isCounted := range s.ballots[targetID].voters {
+		if isCounted {
+			counted[voter] = true
+		}
+	}
+	overlap := 0
+	for _, id := range mergedIDs {
+		for voter, isCounted := range s.ballots[id].voters {
+			if !isCounted {
+				continue
+			}
+			if counted[voter] {
+				overlap++
+			}
+			counted[voter] = true
+		}
+	}
+	s.mu.Unlock()
+
+	q, err := s.repo.Merge(questionnaireID, targetID, mergedIDs, overlap)
+	if err != nil {
+		return MergeResult{}, err
+	}
+	res := MergeResult{Question: q}
+	s.index.Remove(questionnaireID, mergedIDs...)
+	s.index.Add(questionnaireID, q)
+
+	s.mu.Lock()
+	target, found := s.ballots[targetID]
+	if !found {
+		target = s.newBallot(targetID)
+		s.ballots[targetID] = target
+	}
+	for _, id := range mergedIDs {
+		for voter, isCounted := range s.ballots[id].voters {
+			target.voters[voter] = target.voters[voter] || isCounted
+		}
+	}
+	s.mu.Unlock()
+
+	_, err = s.repo.UpdateQuestionnaire(questionnaireID, func(meta *Questionnaire) error {
+		res.Unpinned = slices.Contains(mergedIDs, meta.Pinned)
+		if res.Unpinned {
+			meta.Pinned = ""
+		}
+		res.Unspotlighted = slices.Contains(mergedIDs, meta.Spotlight)
+		if res.Unspotlighted {
+			meta.Spotlight = ""
+		}
+		return nil
+	})
+	if err != nil {
+		return MergeResult{}, err
+	}
+	return res, nil
+}
+
+// maxSuggestions caps how many questions are suggested.
+const maxSuggestions = 5
+
+// Suggest returns the open questions that match what a participant is typing,
+// among the ones visible to them.
+func (s *Service) Suggest(questionnaireID string, text string, viewer Participant) ([]Match, error) {
+	// Questionnaires are loaded into the index the first time they are searched, kept up
+	// to date with the changes made through this replica, and reloaded once in a while.
+	if s.index.Stale(questionnaireID) {
+		fetched := time.Now()
+		_, err := s.repo.GetQuestionnaire(questionnaireID)
+		if err != nil {
+			return nil, err
+		}
+		qs, err := s.repo.GetQuestions(questionnaireID)
+		if err != nil {
+			return nil, err
+		}
+		bans, err := s.repo.GetBans(questionnaireID)
+		if err != nil {
+			return nil, err
+		}
+		s.index.Load(questionnaireID, fetched, qs, bans)
+	}
+
+	return s.index.Search(questionnaireID, text, viewer, maxSuggestions), nil
 }
 
 func (s *Service) CountQuestionnaires() (int, error) {
 	return s.repo.CountQuestionnaires()
 }
 
+func (s *Service) CountQuestionnairesByCreator(creator string) (int, error) {
+	return s.repo.CountQuestionnairesByCreator(creator)
+}
+
 func (s *Service) CountQuestions(questionnaireID string) (int, error) {
 	return s.repo.CountQuestions(questionnaireID)
 }
@@ -0,0 +1,203 @@
+package questionnaire
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestSlowModeByParticipant(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	svc := NewService(NewInMemoryRepo(), Config{
+		TTL:               time.Hour,
+		BallotGCInterval:  time.Hour,
+		MaxQuestions:      50,
+		MaxQuestionLength: 280,
+	}, logger)
+
+	tests := []struct {
+		name   string
+		first  Participant
+		second Participant
+		slowed bool
+	}{
+		{
+			"made up cookies from the same IP",
+			Participant{Voter: "a", IP: "192.0.2.1"},
+			Participant{Voter: "b", IP: "192.0.2.1"},
+			true,
+		},
+		{
+			"issued cookies from the same IP",
+			Participant{Voter: "a", IP: "192.0.2.1", Verified: true},
+			Participant{Voter: "b", IP: "192.0.2.1", Verified: true},
+			false,
+		},
+		{
+			"made up cookies from different IPs",
+			Participant{Voter: "a", IP: "192.0.2.1"},
+			Participant{Voter: "a", IP: "192.0.2.2"},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := svc.Create("AMA", Settings{SlowMode: SlowMode{CooldownSeconds: 60}}, "192.0.2.1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = svc.Ask(q.ID, AskRequest{Text: "What is the roadmap for next year?", Author: tt.first})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = svc.Ask(q.ID, AskRequest{Text: "How do you pick which features to build?", Author: tt.second})
+			var slowModeErr *SlowModeError
+			if slowed := errors.As(err, &slowModeErr); slowed != tt.slowed {
+				t.Errorf("got error %v, want slowed %t", err, tt.slowed)
+			}
+		})
+	}
+}
+
+// failingVoteRepo fails the next vote.
+type failingVoteRepo struct {
+	Repository
+	fail bool
+}
+
+func (r *failingVoteRepo) Vote(questionnaireID string, questionID string) (uint16, error) {
+	if r.fail {
+		r.fail = false
+		return 0, errors.New("repository unavailable")
+	}
+	return r.Repository.Vote(questionnaireID, questionID)
+}
+
+func TestVoteAfterFailedVote(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	repo := &failingVoteRepo{Repository: NewInMemoryRepo()}
+	svc := NewService(repo, Config{
+		TTL:               time.Hour,
+		BallotGCInterval:  time.Hour,
+		MaxQuestions:      50,
+		MaxQuestionLength: 280,
+	}, logger)
+
+	q, err := svc.Create("AMA", Settings{}, "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	question, err := svc.Ask(q.ID, AskRequest{Text: "What is the roadmap for next year?", Author: Participant{IP: "192.0.2.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	voter := Participant{Voter: "a", IP: "192.0.2.2", Verified: true}
+	repo.fail = true
+	_, err = svc.Vote(q.ID, question.ID, voter)
+	if err == nil {
+		t.Fatal("got no error, want the repository error")
+	}
+
+	votes, err := svc.Vote(q.ID, question.ID, voter)
+	if err != nil {
+		t.Fatalf("got error %v voting again", err)
+	}
+	if votes != 1 {
+		t.Errorf("got %d votes, want 1", votes)
+	}
+}
+
+// blockingVoteRepo holds votes until they are released.
+type blockingVoteRepo struct {
+	Repository
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (r *blockingVoteRepo) Vote(questionnaireID string, questionID string) (uint16, error) {
+	r.entered <- struct{}{}
+	<-r.release
+	return r.Repository.Vote(questionnaireID, questionID)
+}
+
+func TestMergeWaitsForPendingVotes(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	repo := &blockingVoteRepo{
+		Repository: NewInMemoryRepo(),
+		entered:    make(chan struct{}),
+		release:    make(chan struct{}),
+	}
+	svc := NewService(repo, Config{
+		TTL:               time.Hour,
+		BallotGCInterval:  time.Hour,
+		MaxQuestions:      50,
+		MaxQuestionLength: 280,
+	}, logger).(*Service)
+
+	q, err := svc.Create("AMA", Settings{Duplicates: DuplicateSettings{Mode: DuplicatesOff}}, "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	author := Participant{IP: "192.0.2.1"}
+	target, err := svc.Ask(q.ID, AskRequest{Text: "What is the roadmap for next year?", Author: author})
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := svc.Ask(q.ID, AskRequest{Text: "What does the roadmap look like?", Author: author})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	voter := Participant{Voter: "a", IP: "192.0.2.2", Verified: true}
+	go func() { <-repo.entered; repo.release <- struct{}{} }()
+	_, err = svc.Vote(q.ID, target.ID, voter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same voter votes the other question, and it is merged while the vote is being saved.
+	voted := make(chan error)
+	go func() {
+		_, err := svc.Vote(q.ID, merged.ID, voter)
+		voted <- err
+	}()
+	<-repo.entered
+
+	done := make(chan MergeResult)
+	go func() {
+		res, err := svc.Merge(q.ID, target.ID, []string{merged.ID})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- res
+	}()
+	for {
+		svc.mu.Lock()
+		merging := svc.merging[target.ID]
+		svc.mu.Unlock()
+		if merging {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	_, err = svc.Vote(q.ID, target.ID, Participant{Voter: "b", IP: "192.0.2.3", Verified: true})
+	if err == nil {
+		t.Error("got no error voting a question being merged")
+	}
+
+	repo.release <- struct{}{}
+	if err := <-voted; err != nil {
+		t.Fatal(err)
+	}
+	res := <-done
+	if res.Question.Metadata.Votes != 1 {
+		t.Errorf("got %d votes, want 1, the voter voted both questions", res.Question.Metadata.Votes)
+	}
+}
@@ -0,0 +1,128 @@
+package questionnaire
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// stopwords are ignored when comparing questions, they say little about what is being asked.
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true,
+	"but": true, "by": true, "can": true, "do": true, "does": true, "for": true, "from": true,
+	"have": true, "how": true, "i": true, "in": true, "is": true, "it": true, "of": true,
+	"on": true, "or": true, "so": true, "that": true, "the": true, "this": true, "to": true,
+	"was": true, "we": true, "what": true, "when": true, "where": true, "which": true,
+	"who": true, "why": true, "will": true, "with": true, "would": true, "you": true, "your": true,
+}
+
+// normalizeTokens lowercases text and splits it into words, leaving stopwords out.
+func normalizeTokens(text string) []string {
+	words := []string{}
+	for _, tok := range splitWords(strings.ToLower(text)) {
+		if tok.word && !stopwords[tok.text] {
+			words = append(words, tok.text)
+		}
+	}
+	return words
+}
+
+// trigrams returns the set of character trigrams of the words,
+// each word padded with spaces so short words still count.
+func trigrams(words []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range words {
+		runes := []rune(" " + w + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			set[string(runes[i:i+3])] = true
+		}
+	}
+	return set
+}
+
+// jaccard is the size of the intersection over the size of the union of two sets.
+func jaccard(a map[string]bool, b map[string]bool) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for k := range a {
+		if b[k] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// Match is an existing question similar to a new one.
+type Match struct {
+	ID       string  `json:"id"`
+	Question string  `json:"question"`
+	Votes    uint16  `json:"votes"`
+	Score    float64 `json:"score"`
+}
+
+// DuplicateError is returned when a question looks like one already asked.
+// The asker can vote on one of the matches instead, or ask anyway.
+type DuplicateError struct {
+	Matches []Match `json:"matches"`
+}
+
+func (e *DuplicateError) Error() string {
+	return "a similar question has already been asked"
+}
+
+type DuplicateMode string
+
+const (
+	// DuplicatesFlag accepts likely duplicates but flags them to the host. It is the default.
+	DuplicatesFlag = DuplicateMode("flag")
+	// DuplicatesSuggest asks the participant to vote on the similar question instead.
+	DuplicatesSuggest = DuplicateMode("suggest")
+	// DuplicatesOff disables duplicate detection.
+	DuplicatesOff = DuplicateMode("off")
+)
+
+// defaultDuplicateThreshold is the similarity above which questions are deemed duplicates.
+const defaultDuplicateThreshold = 0.5
+
+// maxMatches caps how many similar questions are reported.
+const maxMatches = 3
+
+// DuplicateSettings are the per-questionnaire options of duplicate detection.
+type DuplicateSettings struct {
+	Mode DuplicateMode `json:"mode,omitempty"`
+	// Threshold is the similarity, between 0 and 1, above which questions are duplicates.
+	Threshold float64 `json:"threshold,omitempty"`
+}
+
+// findDuplicates returns the open questions similar to text, most similar first.
+// Questions are scored from 0 (nothing in common) to 1 by comparing the trigrams of
+// their normalized words, which tolerates typos, word order and plurals.
+func findDuplicates(qs []Question, text string, threshold float64) []Match {
+	target := trigrams(normalizeTokens(text))
+
+	matches := []Match{}
+	for _, q := range qs {
+		if q.Metadata.Answered {
+			continue
+		}
+		score := jaccard(target, trigrams(normalizeTokens(q.Question)))
+		if score >= threshold {
+			matches = append(matches, Match{
+				ID:       q.ID,
+				Question: q.Question,
+				Votes:    q.Metadata.Votes,
+				Score:    score,
+			})
+		}
+	}
+
+	slices.SortFunc(matches, func(a, b Match) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	if len(matches) > maxMatches {
+		matches = matches[:maxMatches]
+	}
+	return matches
+}
@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// bucket holds the tokens available for a key as of last.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Memory is a token bucket limiter that keeps its buckets in process memory.
+// Each key gets a bucket of Rate.Limit tokens that refills at Rate.Limit per Rate.Period.
+type Memory struct {
+	mu      sync.Mutex
+	rate    Rate
+	buckets map[string]*bucket
+}
+
+// NewMemory creates an in-memory limiter.
+func NewMemory(rate Rate) *Memory {
+	m := &Memory{
+		rate:    rate,
+		buckets: make(map[string]*bucket),
+	}
+
+	go m.gcFullBuckets()
+	return m
+}
+
+// Allow takes a token from the key's bucket, if there is one available.
+func (m *Memory) Allow(key string) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	b, found := m.buckets[key]
+	if !found {
+		b = &bucket{tokens: float64(m.rate.Limit), last: now}
+		m.buckets[key] = b
+	}
+	m.refill(b, now)
+
+	interval := m.rate.interval()
+	res := Result{Limit: m.rate.Limit}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration((1 - b.tokens) * float64(interval))
+	}
+
+	res.Remaining = int(b.tokens)
+	res.ResetAfter = time.Duration((float64(m.rate.Limit) - b.tokens) * float64(interval))
+	return res, nil
+}
+
+func (m *Memory) refill(b *bucket, now time.Time) {
+	elapsed := now.Sub(b.last)
+	b.tokens += float64(elapsed) / float64(m.rate.interval())
+	if b.tokens > float64(m.rate.Limit) {
+		b.tokens = float64(m.rate.Limit)
+	}
+	b.last = now
+}
+
+// gcFullBuckets drops buckets that have refilled completely,
+// they are equivalent to a key that has never been seen.
+func (m *Memory) gcFullBuckets() {
+	for range time.Tick(m.rate.Period) {
+		now := time.Now()
+		m.mu.Lock()
+		for key, b := range m.buckets {
+			m.refill(b, now)
+			if b.tokens >= float64(m.rate.Limit) {
+				delete(m.buckets, key)
+			}
+		}
+		m.mu.Unlock()
+	}
+}
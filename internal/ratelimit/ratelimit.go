@@ -0,0 +1,76 @@
+// Package ratelimit limits how often a key (e.g. a client IP) can perform an action.
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minInterval is the shortest time between requests a Rate can allow.
+const minInterval = time.Microsecond
+
+// Rate is the number of requests allowed per period.
+type Rate struct {
+	Limit  int
+	Period time.Duration
+}
+
+// ParseRate parses a rate in the form "<limit>/<period>", e.g. "10/1m".
+func ParseRate(s string) (Rate, error) {
+	limitStr, periodStr, found := strings.Cut(s, "/")
+	if !found {
+		return Rate{}, fmt.Errorf("invalid rate %q, expected <limit>/<period>", s)
+	}
+
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil {
+		return Rate{}, fmt.Errorf("invalid rate limit %q: %w", limitStr, err)
+	}
+
+	period, err := time.ParseDuration(periodStr)
+	if err != nil {
+		return Rate{}, fmt.Errorf("invalid rate period %q: %w", periodStr, err)
+	}
+
+	if limit <= 0 || period <= 0 {
+		return Rate{}, fmt.Errorf("invalid rate %q, limit and period must be positive", s)
+	}
+
+	// Backends count time in whole microseconds, a shorter interval would be 0.
+	if float64(period)/float64(limit) < float64(minInterval) {
+		return Rate{}, fmt.Errorf("invalid rate %q, at most one request per %s is supported", s, minInterval)
+	}
+
+	return Rate{Limit: limit, Period: period}, nil
+}
+
+func (r Rate) String() string {
+	return fmt.Sprintf("%d/%s", r.Limit, r.Period)
+}
+
+// interval is the time it takes to regain one request.
+func (r Rate) interval() time.Duration {
+	return r.Period / time.Duration(r.Limit)
+}
+
+// Limiter checks keys against a rate.
+// Every backend (in memory, Redis) implements it.
+type Limiter interface {
+	// Allow consumes one request for key, if the rate allows it.
+	Allow(key string) (Result, error)
+}
+
+// Result is the outcome of checking a key against a limiter.
+type Result struct {
+	Allowed bool
+	// Limit is the maximum number of requests in a burst.
+	Limit int
+	// Remaining is the number of requests that can be made right away.
+	Remaining int
+	// ResetAfter is the time until the key has its full limit available again.
+	ResetAfter time.Duration
+	// RetryAfter is the time until the next request is allowed, 0 if Allowed.
+	RetryAfter time.Duration
+}
@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// gcra implements the Generic Cell Rate Algorithm, which behaves like a token
+// bucket but only needs to store a single timestamp per key: the theoretical
+// arrival time (TAT) of the next request. Times are in microseconds and taken
+// from the Redis server so replicas do not need synchronized clocks.
+//
+// KEYS[1]: key, ARGV[1]: emission interval, ARGV[2]: burst.
+// Returns {allowed, remaining, retry_after, reset_after}.
+var gcra = redis.NewScript(`
+if redis.replicate_commands then redis.replicate_commands() end
+
+local key = KEYS[1]
+local emission = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local tat = tonumber(redis.call("GET", key))
+if not tat or tat < now then
+  tat = now
+end
+
+local new_tat = tat + emission
+local allow_at = new_tat - emission * burst
+local diff = now - allow_at
+
+if diff < 0 then
+  return {0, 0, -diff, tat - now}
+end
+
+local reset_after = new_tat - now
+redis.call("SET", key, new_tat, "PX", math.ceil(reset_after / 1000))
+return {1, math.floor(diff / emission), 0, reset_after}
+`)
+
+// Redis is a GCRA limiter that stores its state in Redis,
+// so that every replica of the server shares the same limits.
+type Redis struct {
+	client *redis.Client
+	prefix string
+	rate   Rate
+}
+
+// NewRedis creates a Redis backed limiter, keys are stored with the given prefix.
+func NewRedis(client *redis.Client, prefix string, rate Rate) *Redis {
+	return &Redis{
+		client: client,
+		prefix: prefix,
+		rate:   rate,
+	}
+}
+
+func (r *Redis) Allow(key string) (Result, error) {
+	emission := r.rate.interval().Microseconds()
+	vals, err := gcra.Run(context.TODO(), r.client, []string{r.prefix + key}, emission, r.rate.Limit).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Allowed:    vals[0] == 1,
+		Limit:      r.rate.Limit,
+		Remaining:  int(vals[1]),
+		RetryAfter: time.Duration(vals[2]) * time.Microsecond,
+		ResetAfter: time.Duration(vals[3]) * time.Microsecond,
+	}, nil
+}
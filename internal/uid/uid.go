@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"encoding/base32"
 	"fmt"
+	"strconv"
 	"time"
 )
 
@@ -23,3 +24,17 @@ func Generate(withTimestamp bool, len int) string {
 	timestamp := time.Now().Unix()
 	return fmt.Sprintf("%d%s", timestamp, plaintext)
 }
+
+// Timestamp returns the time an ID generated withTimestamp was created.
+// It returns false if the ID has no timestamp.
+func Timestamp(id string) (time.Time, bool) {
+	// Unix timestamps have 10 digits until the year 2286.
+	if len(id) < 10 {
+		return time.Time{}, false
+	}
+	secs, err := strconv.ParseInt(id[:10], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(secs, 0), true
+}
@@ -0,0 +1,47 @@
+// Package voter issues the IDs stored in the voter cookie of participants.
+//
+// IDs carry the time they were issued at, which the vote analyzer uses to tell
+// fresh cookies apart. They are signed, so clients cannot backdate them.
+package voter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/germandv/ama/internal/uid"
+)
+
+// Issuer issues and verifies voter IDs.
+type Issuer struct {
+	secret []byte
+}
+
+// New creates an Issuer that signs IDs with secret.
+func New(secret []byte) *Issuer {
+	return &Issuer{secret: secret}
+}
+
+// Issue creates a new voter ID in the form "<timestamped ID>.<signature>".
+func (i *Issuer) Issue() string {
+	id := uid.Generate(true, 32)
+	return id + "." + i.sign(id)
+}
+
+// IssuedAt returns the time the voter ID was issued at.
+// It returns false if the ID was not issued by an Issuer with the same secret.
+func (i *Issuer) IssuedAt(voter string) (time.Time, bool) {
+	id, sig, found := strings.Cut(voter, ".")
+	if !found || !hmac.Equal([]byte(sig), []byte(i.sign(id))) {
+		return time.Time{}, false
+	}
+	return uid.Timestamp(id)
+}
+
+func (i *Issuer) sign(id string) string {
+	mac := hmac.New(sha256.New, i.secret)
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -8,6 +8,9 @@ import (
 	"time"
 )
 
+// maxBodyBytes caps the size of request bodies.
+const maxBodyBytes = 64 << 10
+
 type Web struct {
 	cookieExp time.Duration
 	logger    *slog.Logger
@@ -84,6 +87,7 @@ func (web Web) SetCookie(w http.ResponseWriter, name string, value string) {
 }
 
 func (web Web) DecodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
 	err := json.NewDecoder(r.Body).Decode(v)
 	if err != nil {
 		web.BadRequest(w, err)
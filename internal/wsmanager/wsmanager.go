@@ -1,61 +1,267 @@
 package wsmanager
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// ErrShuttingDown is returned when adding clients after Shutdown has been called.
+var ErrShuttingDown = errors.New("websocket manager is shutting down")
+
+const (
+	// sendQueueSize is how many messages can wait to be written to a client.
+	// Clients falling further behind are disconnected.
+	sendQueueSize = 64
+	// writeWait is how long writing a message to a client can take.
+	writeWait = 10 * time.Second
+)
+
+// message is a WebSocket message waiting to be written to a client.
+type message struct {
+	kind int
+	data []byte
+}
+
+// client is a connection in a room. Messages to it are queued and written by
+// its own goroutine, so a slow client does not hold up the others.
+type client struct {
+	host bool
+	send chan message
+}
+
+// debounced is a pending debounced call.
+type debounced struct {
+	timer *time.Timer
+	first time.Time
+}
+
 // WSManager handles a WebSocket, its rooms and their clients.
+// Each client in a room is flagged as host or not.
 type WSManager struct {
-	rooms    map[string]map[*websocket.Conn]bool
+	mu       sync.Mutex
+	closing  bool
+	clients  sync.WaitGroup
+	rooms    map[string]map[*websocket.Conn]*client
+	timers   map[string]*debounced
+	ips      map[string]int
+	maxPerIP int
 	Upgrader websocket.Upgrader
 }
 
 // New creates a WSManager.
-func New() *WSManager {
+// Upgrades are only allowed from pages served by domain,
+// and each IP can hold at most maxPerIP connections at a time.
+func New(domain string, maxPerIP int) *WSManager {
 	return &WSManager{
-		rooms:    make(map[string]map[*websocket.Conn]bool),
-		Upgrader: websocket.Upgrader{},
+		rooms:    make(map[string]map[*websocket.Conn]*client),
+		timers:   make(map[string]*debounced),
+		ips:      make(map[string]int),
+		maxPerIP: maxPerIP,
+		Upgrader: websocket.Upgrader{
+			CheckOrigin: sameDomain(domain),
+		},
 	}
 }
 
-// AddClient adds a client connection to a room.
+// sameDomain returns an origin check that accepts requests whose Origin host is domain.
+// Requests without an Origin header do not come from a browser and are accepted.
+func sameDomain(domain string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		return strings.EqualFold(u.Hostname(), domain)
+	}
+}
+
+// AcquireIP reserves a connection slot for ip.
+// It returns false if ip already holds the maximum number of connections.
+func (wsm *WSManager) AcquireIP(ip string) bool {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
+	if wsm.ips[ip] >= wsm.maxPerIP {
+		return false
+	}
+	wsm.ips[ip]++
+	return true
+}
+
+// ReleaseIP frees a connection slot previously reserved with AcquireIP.
+func (wsm *WSManager) ReleaseIP(ip string) {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
+	wsm.ips[ip]--
+	if wsm.ips[ip] <= 0 {
+		delete(wsm.ips, ip)
+	}
+}
+
+// AddClient adds a client connection to a room, host tells if the client hosts the room.
 // If the room does not exists, it creates it.
-func (wsm *WSManager) AddClient(id string, c *websocket.Conn) {
+// It returns ErrShuttingDown if the manager is no longer accepting clients.
+func (wsm *WSManager) AddClient(id string, c *websocket.Conn, host bool) error {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
+	if wsm.closing {
+		return ErrShuttingDown
+	}
 	clients, found := wsm.rooms[id]
 	if !found {
-		clients = make(map[*websocket.Conn]bool)
+		clients = make(map[*websocket.Conn]*client)
 		wsm.rooms[id] = clients
 	}
-	clients[c] = true
+	cl := &client{host: host, send: make(chan message, sendQueueSize)}
+	clients[c] = cl
+	wsm.clients.Add(1)
+	go writeMessages(c, cl.send)
+	return nil
+}
+
+// writeMessages writes the messages queued for a client until its queue is closed.
+// The connection is closed on the first failed write, which ends its reader too.
+func writeMessages(c *websocket.Conn, send <-chan message) {
+	for msg := range send {
+		deadline := time.Now().Add(writeWait)
+		var err error
+		if msg.kind == websocket.CloseMessage {
+			err = c.WriteControl(msg.kind, msg.data, deadline)
+		} else {
+			c.SetWriteDeadline(deadline)
+			err = c.WriteMessage(msg.kind, msg.data)
+		}
+		if err != nil {
+			c.Close()
+			return
+		}
+	}
+}
+
+// enqueue queues a message for a client without blocking, the caller must hold the lock.
+// Clients whose queue is full are too slow to keep up, they are disconnected.
+func enqueue(c *websocket.Conn, cl *client, msg message) {
+	select {
+	case cl.send <- msg:
+	default:
+		c.Close()
+	}
 }
 
 // RemoveClient removes a client connection from a room.
 // It does nothing if the room or client do not exist.
 func (wsm *WSManager) RemoveClient(id string, c *websocket.Conn) {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
 	clients, found := wsm.rooms[id]
-	if !found {
+	cl, ok := clients[c]
+	if !found || !ok {
 		return
 	}
 	delete(clients, c)
+	close(cl.send)
+	wsm.clients.Done()
 }
 
 // DeleteRoom deletes the room and all its clients connections (if any).
 // It does nothing if the room does not exist.
 func (wsm *WSManager) DeleteRoom(id string) {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
+	for _, cl := range wsm.rooms[id] {
+		close(cl.send)
+		wsm.clients.Done()
+	}
 	delete(wsm.rooms, id)
 }
 
+// IsClosing returns true once Shutdown has been called.
+func (wsm *WSManager) IsClosing() bool {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
+	return wsm.closing
+}
+
+// Shutdown stops accepting clients and sends a close frame with the given reason
+// to every connected client. It then waits for clients to disconnect, or for ctx
+// to be done, in which case remaining connections are closed abruptly.
+func (wsm *WSManager) Shutdown(ctx context.Context, reason string) error {
+	wsm.mu.Lock()
+	wsm.closing = true
+	for _, d := range wsm.timers {
+		d.timer.Stop()
+	}
+	// The close frame is queued after the messages already broadcast, so they are written first.
+	msg := message{
+		kind: websocket.CloseMessage,
+		data: websocket.FormatCloseMessage(websocket.CloseServiceRestart, reason),
+	}
+	for _, clients := range wsm.rooms {
+		for c, cl := range clients {
+			enqueue(c, cl, msg)
+		}
+	}
+	wsm.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		wsm.clients.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		wsm.mu.Lock()
+		for _, clients := range wsm.rooms {
+			for c := range clients {
+				c.Close()
+			}
+		}
+		wsm.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
 // Broadcast sends a message to all clients in the room (including emitter).
+// Messages are queued, it does not wait for them to be written.
 func (wsm *WSManager) Broadcast(room string, data []byte) error {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
 	clients, found := wsm.rooms[room]
 	if !found {
 		return fmt.Errorf("room %q does not exist", room)
 	}
-	for c := range clients {
-		c.WriteMessage(1, data)
+	for c, cl := range clients {
+		enqueue(c, cl, message{kind: websocket.TextMessage, data: data})
+	}
+	return nil
+}
+
+// BroadcastHosts sends a message to the hosts in the room only.
+func (wsm *WSManager) BroadcastHosts(room string, data []byte) error {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
+	clients, found := wsm.rooms[room]
+	if !found {
+		return fmt.Errorf("room %q does not exist", room)
+	}
+	for c, cl := range clients {
+		if cl.host {
+			enqueue(c, cl, message{kind: websocket.TextMessage, data: data})
+		}
 	}
 	return nil
 }
@@ -63,6 +269,8 @@ func (wsm *WSManager) Broadcast(room string, data []byte) error {
 // CountClients counts clients connected to a room.
 // If room does not exists, it returns 0.
 func (wsm *WSManager) CountClients(room string) (int, error) {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
 	clients, found := wsm.rooms[room]
 	if !found {
 		return 0, nil
@@ -72,6 +280,8 @@ func (wsm *WSManager) CountClients(room string) (int, error) {
 
 // Stats returns the count of clients per room.
 func (wsm *WSManager) Stats() string {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
 	sb := strings.Builder{}
 	for room, clients := range wsm.rooms {
 		sb.WriteString("room ")
@@ -83,6 +293,43 @@ func (wsm *WSManager) Stats() string {
 	return sb.String()
 }
 
+// Debounce runs fn once delay has passed without another call for the same key.
+// Every call within the delay window pushes the execution back, but never further
+// than maxWait after the first call, so that a steady stream of calls still runs fn.
+// Once shutting down, fn is not scheduled, there are no clients left to notify.
+func (wsm *WSManager) Debounce(key string, delay time.Duration, maxWait time.Duration, fn func()) {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
+
+	if wsm.closing {
+		return
+	}
+
+	now := time.Now()
+	d, found := wsm.timers[key]
+	if found {
+		d.timer.Stop()
+	} else {
+		d = &debounced{first: now}
+		wsm.timers[key] = d
+	}
+
+	wait := min(delay, d.first.Add(maxWait).Sub(now))
+	var t *time.Timer
+	t = time.AfterFunc(wait, func() {
+		wsm.mu.Lock()
+		if d.timer != t || wsm.closing {
+			// Pushed back by a later call, or stopped by Shutdown, while this one was firing.
+			wsm.mu.Unlock()
+			return
+		}
+		delete(wsm.timers, key)
+		wsm.mu.Unlock()
+		fn()
+	})
+	d.timer = t
+}
+
 // IsCloseError retruns true if error is an expected disconnection error.
 func (wsm *WSManager) IsCloseError(err error) bool {
 	return websocket.IsCloseError(
@@ -90,5 +337,6 @@ func (wsm *WSManager) IsCloseError(err error) bool {
 		websocket.CloseNormalClosure,
 		websocket.CloseGoingAway,
 		websocket.CloseNoStatusReceived,
+		websocket.CloseServiceRestart,
 	)
 }
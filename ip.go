@@ -1,42 +1,183 @@
 package main
 
 import (
+	"fmt"
+	"net"
 	"net/http"
 	"strings"
 )
 
 var (
-	trueClientIP  = http.CanonicalHeaderKey("True-Client-IP")
+	forwarded     = http.CanonicalHeaderKey("Forwarded")
 	xForwardedFor = http.CanonicalHeaderKey("X-Forwarded-For")
 	xRealIP       = http.CanonicalHeaderKey("X-Real-IP")
+	trueClientIP  = http.CanonicalHeaderKey("True-Client-IP")
 )
 
-// RealIP is a middleware that sets the http.Request's RemoteAddr to the results
-// of parsing either the True-Client-IP, X-Real-IP or the X-Forwarded-For headers (in that order).
-func realIP(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := getIP(r)
-		if ip != "" {
-			r.RemoteAddr = ip
+// parseCIDRs parses a comma-separated list of CIDRs or single IPs.
+func parseCIDRs(list string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
 		}
-		next.ServeHTTP(w, r)
-	})
-}
 
-func getIP(r *http.Request) string {
-	var ip string
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
 
-	if tcip := r.Header.Get(trueClientIP); tcip != "" {
-		ip = tcip
-	} else if xrip := r.Header.Get(xRealIP); xrip != "" {
-		ip = xrip
-	} else if xff := r.Header.Get(xForwardedFor); xff != "" {
-		i := strings.Index(xff, ", ")
-		if i == -1 {
-			i = len(xff)
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
 		}
-		ip = xff[:i] // first IP in the comma-separated list
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// parseProxyHeader validates the name of the header trusted proxies tell the client IP with.
+func parseProxyHeader(name string) (string, error) {
+	header := http.CanonicalHeaderKey(name)
+	switch header {
+	case forwarded, xForwardedFor, xRealIP, trueClientIP:
+		return header, nil
+	default:
+		return "", fmt.Errorf("unsupported header %q, must be one of %s, %s, %s or %s", name, forwarded, xForwardedFor, xRealIP, trueClientIP)
+	}
+}
+
+// RealIP is a middleware that sets the http.Request's RemoteAddr to the IP of the client.
+// Only the header set by the trusted proxies is read, and only when the request comes from
+// one of them, otherwise anyone could spoof their IP. Any other forwarding header may come
+// from the client and is ignored. The Forwarded and X-Forwarded-For chains are walked right
+// to left, skipping trusted proxies, and the first untrusted hop is the client.
+func realIP(trusted []*net.IPNet, header string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.RemoteAddr = getIP(r, trusted, header)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func getIP(r *http.Request, trusted []*net.IPNet, header string) string {
+	peer := clientIP(r)
+	if !isTrusted(peer, trusted) {
+		return peer
+	}
+
+	values := r.Header.Values(header)
+	if len(values) == 0 {
+		return peer
 	}
 
+	ip := ""
+	switch header {
+	case forwarded:
+		ip = walkChain(parseForwarded(values), trusted)
+	case xForwardedFor:
+		ip = walkChain(parseXForwardedFor(values), trusted)
+	default:
+		// Single value headers, the proxy overwrites whatever the client sent.
+		ip = parseIP(values[len(values)-1])
+	}
+	if ip == "" {
+		return peer
+	}
 	return ip
 }
+
+// walkChain returns the rightmost hop that is not a trusted proxy.
+// If every hop is trusted, the leftmost one is the client.
+func walkChain(hops []string, trusted []*net.IPNet) string {
+	for i := len(hops) - 1; i >= 0; i-- {
+		if hops[i] == "" {
+			// Unknown or obfuscated hop, we cannot tell who is behind it.
+			return ""
+		}
+		if !isTrusted(hops[i], trusted) {
+			return hops[i]
+		}
+	}
+	if len(hops) > 0 {
+		return hops[0]
+	}
+	return ""
+}
+
+func parseXForwardedFor(values []string) []string {
+	hops := []string{}
+	for _, v := range values {
+		for _, hop := range strings.Split(v, ",") {
+			hops = append(hops, parseIP(hop))
+		}
+	}
+	return hops
+}
+
+// parseForwarded extracts the "for" parameters of an RFC 7239 Forwarded header,
+// e.g. `for=192.0.2.60;proto=http;by=203.0.113.43, for="[2001:db8:cafe::17]:4711"`.
+func parseForwarded(values []string) []string {
+	hops := []string{}
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			hop := ""
+			for _, pair := range strings.Split(elem, ";") {
+				key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(key, "for") {
+					hop = parseIP(strings.Trim(val, `"`))
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// parseIP returns the IP in s, which may have a port and brackets around IPv6 addresses.
+// It returns an empty string if s is not an IP.
+func parseIP(s string) string {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+func isTrusted(ip string, trusted []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the request's RemoteAddr without the port, if it has one.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
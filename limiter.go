@@ -4,11 +4,22 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/germandv/ama/internal/ratelimit"
+	"github.com/germandv/ama/internal/voter"
 	"github.com/germandv/ama/internal/webutils"
 )
 
+// capRetryAfter is what limits on totals suggest waiting, as they free up
+// when resources expire or clients leave rather than at a known time.
+// Only rateLimiter sets the RateLimit-* headers, so stacked limiters
+// never mix numbers from different limits.
+const capRetryAfter = time.Minute
+
 func globalLimiter(
 	limit int,
 	countGetter func() (int, error),
@@ -24,6 +35,7 @@ func globalLimiter(
 			}
 
 			if current >= limit {
+				setRetryAfter(w, capRetryAfter)
 				web.TooManyRequests(w, fmt.Sprintf("reached limit of %d for %s %s", current, r.Method, r.URL.Path))
 				return
 			}
@@ -42,7 +54,7 @@ func globalLimiter(
 }
 
 func idLimiter(
-	limit int,
+	limitGetter func(id string) (int, error),
 	countGetter func(id string) (int, error),
 	logger *slog.Logger,
 	web webutils.Web,
@@ -55,6 +67,12 @@ func idLimiter(
 				return
 			}
 
+			limit, err := limitGetter(questionnaireID)
+			if err != nil {
+				web.NotFound(w, "questionnaire", questionnaireID)
+				return
+			}
+
 			current, err := countGetter(questionnaireID)
 			if err != nil {
 				web.InternalError(w, err)
@@ -62,6 +80,7 @@ func idLimiter(
 			}
 
 			if current >= limit {
+				setRetryAfter(w, capRetryAfter)
 				web.TooManyRequests(w, fmt.Sprintf("reached limit of %d for %s %s", current, r.Method, r.URL.Path))
 				return
 			}
@@ -78,3 +97,125 @@ func idLimiter(
 		})
 	}
 }
+
+// clientLimiter caps how many resources each client, identified by IP, can hold at a time.
+func clientLimiter(
+	limit int,
+	countGetter func(client string) (int, error),
+	logger *slog.Logger,
+	web webutils.Web,
+) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client := clientIP(r)
+
+			current, err := countGetter(client)
+			if err != nil {
+				web.InternalError(w, err)
+				return
+			}
+
+			if current >= limit {
+				setRetryAfter(w, capRetryAfter)
+				web.TooManyRequests(w, fmt.Sprintf("you already have %d live questionnaires, which is the limit per client, try again once one expires", current))
+				return
+			}
+
+			logger.Debug(
+				"rate limit stats",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"client", client,
+				"current", current+1,
+				"limit", limit,
+			)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// fixedLimit is a limit getter for idLimiter that returns the same limit for every ID.
+func fixedLimit(limit int) func(id string) (int, error) {
+	return func(string) (int, error) {
+		return limit, nil
+	}
+}
+
+// rateLimiter limits how often each client can hit the route, clients are told apart by keyFn.
+func rateLimiter(
+	limiter ratelimit.Limiter,
+	keyFn func(r *http.Request) string,
+	logger *slog.Logger,
+	web webutils.Web,
+) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := keyFn(r)
+
+			res, err := limiter.Allow(key)
+			if err != nil {
+				web.InternalError(w, err)
+				return
+			}
+
+			setRateLimitHeaders(w, res)
+			if !res.Allowed {
+				setRetryAfter(w, res.RetryAfter)
+				web.TooManyRequests(w, fmt.Sprintf("too many requests for %s %s, retry in %s", r.Method, r.URL.Path, res.RetryAfter.Round(time.Second)))
+				return
+			}
+
+			logger.Debug(
+				"rate limit stats",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"key", key,
+				"remaining", res.Remaining,
+				"limit", res.Limit,
+			)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// keyByIP identifies clients by their IP.
+func keyByIP(r *http.Request) string {
+	return "ip:" + clientIP(r)
+}
+
+// keyByVoter identifies clients by their voter cookie, falling back to their IP
+// when they have none or it was not issued by the server. Otherwise clients could
+// get a fresh limit with every request by making up cookies.
+func keyByVoter(voters *voter.Issuer) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		cookie, err := r.Cookie("voter")
+		if err != nil || cookie.Value == "" {
+			return keyByIP(r)
+		}
+		_, ok := voters.IssuedAt(cookie.Value)
+		if !ok {
+			return keyByIP(r)
+		}
+		return "voter:" + cookie.Value
+	}
+}
+
+// setRateLimitHeaders lets clients know where they stand with the
+// RateLimit-* headers from the IETF draft on rate limit headers.
+func setRateLimitHeaders(w http.ResponseWriter, res ratelimit.Result) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.ResetAfter)))
+}
+
+// setRetryAfter tells clients how long to wait before trying again.
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(max(ceilSeconds(d), 1)))
+}
+
+// ceilSeconds rounds d up to whole seconds, as expected by the headers.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/germandv/ama/internal/voter"
+)
+
+func TestKeyByVoter(t *testing.T) {
+	voters := voter.New([]byte("secret"))
+	issued := voters.Issue()
+
+	tests := []struct {
+		name   string
+		cookie string
+		want   string
+	}{
+		{"no cookie", "", "ip:192.0.2.1"},
+		{"issued cookie", issued, "voter:" + issued},
+		{"made up cookie", "1700000000ABCDEF", "ip:192.0.2.1"},
+		{"cookie signed with another secret", voter.New([]byte("other")).Issue(), "ip:192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: "voter", Value: tt.cookie})
+			}
+			got := keyByVoter(voters)(r)
+			if got != tt.want {
+				t.Errorf("got key %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -11,9 +11,14 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/germandv/ama/internal/pow"
+	"github.com/germandv/ama/internal/protocol"
 	"github.com/germandv/ama/internal/questionnaire"
+	"github.com/germandv/ama/internal/ratelimit"
+	"github.com/germandv/ama/internal/voter"
 	"github.com/germandv/ama/internal/webutils"
 	"github.com/germandv/ama/internal/wsmanager"
+	"github.com/redis/go-redis/v9"
 )
 
 func main() {
@@ -26,31 +31,56 @@ func main() {
 		Level: cfg.LogLevel,
 	}))
 
-	web := webutils.New(cfg.TTL, logger, cfg.Domain, cfg.Port, cfg.Secure)
-	wsm := wsmanager.New()
-	repo := questionnaire.NewRedisRepo(cfg.RedisHost, cfg.RedisPort, cfg.RedisPass, cfg.TTL)
-	svc := questionnaire.NewService(repo, cfg.TTL, logger)
+	for _, key := range cfg.GeneratedSecrets {
+		logger.Warn("Secret not set, using a random one that changes on restart", "env", key)
+	}
 
-	qLimiter := globalLimiter(20, svc.CountQuestionnaires, logger, web)
-	qsLimiter := idLimiter(100, svc.CountQuestions, logger, web)
-	cLimiter := idLimiter(100, wsm.CountClients, logger, web)
+	web := webutils.New(cfg.TTL, logger, cfg.Domain, cfg.Port, cfg.Secure)
+	wsm := wsmanager.New(cfg.Domain, cfg.WSMaxConnsPerIP)
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%d", cfg.RedisHost, cfg.RedisPort),
+		Password: cfg.RedisPass,
+		DB:       0,
+	})
+	repo := questionnaire.NewRedisRepo(redisClient, cfg.TTL)
+	voters := voter.New(cfg.VoterSecret)
+	analyzer := questionnaire.NewAnalyzer(cfg.Anomaly, voters.IssuedAt, func(f questionnaire.Flag) {
+		logger.Warn("suspicious voting", "questionnaire", f.Questionnaire, "question", f.Question, "kind", f.Kind, "count", f.Count)
+		jsonMsg, err := protocol.Encode(protocol.NewVoteFlag(string(f.Kind), f.Question, f.Count, f.Window))
+		if err != nil {
+			logger.Error("error encoding vote flag message", "err", err)
+			return
+		}
+		wsm.BroadcastHosts(f.Questionnaire, jsonMsg)
+	})
+	svc := questionnaire.NewService(repo, questionnaire.Config{
+		TTL:                 cfg.TTL,
+		BallotGCInterval:    cfg.Limits.BallotGCInterval,
+		MaxQuestions:        cfg.Limits.MaxQuestions,
+		MaxQuestionsCeiling: cfg.Limits.MaxQuestionsCeiling,
+		Analyzer:            analyzer,
+		MaxQuestionLength:   cfg.Limits.MaxQuestionLength,
+		Blocklist:           cfg.Blocklist,
+	}, logger)
+	challenger := pow.New(cfg.PowSecret, cfg.PowDifficulty, cfg.PowTTL)
+	votes := newVoteCoalescer(cfg.VoteFlushInterval, wsm, logger)
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("GET /ws", wsHandler(wsm, svc, logger, web))
-	mux.HandleFunc("GET /", homePageHandler(web))
-	mux.Handle("GET /{id}", cLimiter(questionnairePageHandler(svc, web)))
-	mux.Handle("POST /questionnaires", qLimiter(newQuestionnaireHandler(svc, web)))
-	mux.Handle("POST /questionnaires/{id}/questions", qsLimiter(newQuestionHandler(svc, wsm, web)))
-	mux.HandleFunc("GET /questionnaires/{id}/questions", getQuestionsHandler(svc, web))
-	mux.HandleFunc("PUT /questionnaires/{id}/questions/{question_id}/vote", voteHandler(svc, wsm, web))
-	mux.HandleFunc("PUT /questionnaires/{id}/questions/{question_id}/answer", answerHandler(svc, wsm, web))
+	newLimiter := func(name string, rate ratelimit.Rate) ratelimit.Limiter {
+		return ratelimit.NewMemory(rate)
+	}
+	if cfg.RateLimitBackend == "redis" {
+		newLimiter = func(name string, rate ratelimit.Rate) ratelimit.Limiter {
+			return ratelimit.NewRedis(redisClient, fmt.Sprintf("RL:%s:", name), rate)
+		}
+	}
+	mux := newRouter(cfg, svc, wsm, challenger, voters, votes, newLimiter, logger, web)
 
 	server := &http.Server{
 		Addr:              fmt.Sprintf(":%d", cfg.Port),
-		Handler:           realIP(mux),
-		ReadHeaderTimeout: 2 * time.Second,
-		ReadTimeout:       5 * time.Second,
-		WriteTimeout:      5 * time.Second,
+		Handler:           realIP(cfg.TrustedProxies, cfg.TrustedProxyHeader)(mux),
+		ReadHeaderTimeout: cfg.Limits.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Limits.ReadTimeout,
+		WriteTimeout:      cfg.Limits.WriteTimeout,
 	}
 
 	killCh := make(chan os.Signal, 1)
@@ -70,7 +100,19 @@ func main() {
 	<-killCh
 	logger.Info("Shutdown signal received")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	deadline := time.Now().Add(cfg.Limits.ShutdownTimeout)
+	// Draining WS clients gets half of the timeout, so that if they hang,
+	// in-flight HTTP requests still have the rest to complete.
+	wsCtx, wsCancel := context.WithTimeout(context.Background(), cfg.Limits.ShutdownTimeout/2)
+
+	votes.Flush()
+	err = wsm.Shutdown(wsCtx, shutdownReason)
+	wsCancel()
+	if err != nil {
+		logger.Warn("Timed out draining WS clients", "err", err)
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
 	err = server.Shutdown(ctx)
 	if err != nil {
 		logger.Error("Failed to shut down gracefully", "err", err)
@@ -81,3 +123,57 @@ func main() {
 	cancel()
 	logger.Info("Shutdown completed")
 }
+
+// newRouter registers the routes of the server, with the limiters guarding them.
+func newRouter(
+	cfg *AppConfig,
+	svc questionnaire.IService,
+	wsm *wsmanager.WSManager,
+	challenger *pow.Challenger,
+	voters *voter.Issuer,
+	votes *voteCoalescer,
+	newLimiter func(name string, rate ratelimit.Rate) ratelimit.Limiter,
+	logger *slog.Logger,
+	web webutils.Web,
+) *http.ServeMux {
+	qLimiter := globalLimiter(cfg.Limits.MaxQuestionnaires, svc.CountQuestionnaires, logger, web)
+	qcLimiter := clientLimiter(cfg.Limits.MaxQuestionnairesPerClient, svc.CountQuestionnairesByCreator, logger, web)
+	qsLimiter := idLimiter(svc.QuestionLimit, svc.CountQuestions, logger, web)
+	cLimiter := idLimiter(fixedLimit(cfg.Limits.MaxClients), wsm.CountClients, logger, web)
+
+	participantKey := keyByIP
+	if cfg.RateLimitByVoter {
+		participantKey = keyByVoter(voters)
+	}
+	qRate := rateLimiter(newLimiter("questionnaires", cfg.QuestionnaireRate), keyByIP, logger, web)
+	qsRate := rateLimiter(newLimiter("questions", cfg.QuestionRate), participantKey, logger, web)
+	vRate := rateLimiter(newLimiter("votes", cfg.VoteRate), participantKey, logger, web)
+	qrRate := rateLimiter(newLimiter("qr", cfg.QRRate), keyByIP, logger, web)
+	botGuard := botProtection(svc, challenger, web)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ws", wsHandler(wsm, svc, logger, web))
+	mux.HandleFunc("GET /ws/schema.json", wsSchemaHandler())
+	mux.HandleFunc("GET /", homePageHandler(web))
+	mux.Handle("GET /{id}", cLimiter(questionnairePageHandler(svc, voters, web)))
+	mux.Handle("GET /panelists/{id}/{panelist}", cLimiter(panelistPageHandler(svc, voters, web)))
+	mux.Handle("GET /{id}/present", cLimiter(presentPageHandler(svc, voters, web)))
+	mux.Handle("GET /{id}/qr.png", qrRate(qrHandler(svc, web, "png")))
+	mux.Handle("GET /{id}/qr.svg", qrRate(qrHandler(svc, web, "svg")))
+	mux.Handle("POST /questionnaires", qRate(qcLimiter(qLimiter(newQuestionnaireHandler(svc, web)))))
+	mux.Handle("POST /questionnaires/{id}/questions", qsRate(botGuard(qsLimiter(newQuestionHandler(svc, wsm, voters, web)))))
+	mux.HandleFunc("PUT /questionnaires/{id}/settings", settingsHandler(svc, web))
+	mux.HandleFunc("PUT /questionnaires/{id}/pin", pinHandler(svc, wsm, web))
+	mux.HandleFunc("PUT /questionnaires/{id}/spotlight", spotlightHandler(svc, wsm, web))
+	mux.HandleFunc("GET /questionnaires/{id}/challenge", challengeHandler(svc, challenger, web))
+	mux.HandleFunc("GET /questionnaires/{id}/questions", getQuestionsHandler(svc, voters, web))
+	mux.HandleFunc("GET /questionnaires/{id}/questions/suggest", suggestHandler(svc, voters, web))
+	mux.HandleFunc("GET /questionnaires/{id}/questions/search", searchHandler(svc, voters, web))
+	mux.Handle("PUT /questionnaires/{id}/questions/{question_id}/vote", vRate(botGuard(voteHandler(svc, voters, votes, web))))
+	mux.HandleFunc("PUT /questionnaires/{id}/questions/{question_id}/answer", answerHandler(svc, wsm, web))
+	mux.HandleFunc("PUT /questionnaires/{id}/questions/{question_id}/tag", tagHandler(svc, wsm, web))
+	mux.HandleFunc("POST /questionnaires/{id}/questions/{question_id}/ban", banHandler(svc, web))
+	mux.HandleFunc("POST /questionnaires/{id}/questions/{question_id}/merge", mergeHandler(svc, wsm, web))
+
+	return mux
+}
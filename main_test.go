@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/germandv/ama/internal/pow"
+	"github.com/germandv/ama/internal/questionnaire"
+	"github.com/germandv/ama/internal/ratelimit"
+	"github.com/germandv/ama/internal/voter"
+	"github.com/germandv/ama/internal/webutils"
+	"github.com/germandv/ama/internal/wsmanager"
+)
+
+func TestRoutes(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &AppConfig{
+		QuestionnaireRate: ratelimit.Rate{Limit: 3, Period: 10 * time.Minute},
+		QuestionRate:      ratelimit.Rate{Limit: 10, Period: time.Minute},
+		VoteRate:          ratelimit.Rate{Limit: 60, Period: time.Minute},
+		QRRate:            ratelimit.Rate{Limit: 30, Period: time.Minute},
+		Limits: Limits{
+			MaxQuestionnaires:          20,
+			MaxQuestionnairesPerClient: 3,
+			MaxQuestions:               50,
+			MaxQuestionsCeiling:        500,
+			MaxClients:                 100,
+			MaxQuestionLength:          280,
+			BallotGCInterval:           time.Hour,
+		},
+	}
+	web := webutils.New(time.Hour, logger, "localhost", 8080, false)
+	wsm := wsmanager.New("localhost", 10)
+	svc := questionnaire.NewService(questionnaire.NewInMemoryRepo(), questionnaire.Config{
+		TTL:                 time.Hour,
+		BallotGCInterval:    time.Hour,
+		MaxQuestions:        cfg.Limits.MaxQuestions,
+		MaxQuestionsCeiling: cfg.Limits.MaxQuestionsCeiling,
+		MaxQuestionLength:   cfg.Limits.MaxQuestionLength,
+	}, logger)
+	newLimiter := func(name string, rate ratelimit.Rate) ratelimit.Limiter {
+		return ratelimit.NewMemory(rate)
+	}
+
+	// Registering conflicting patterns panics, so this fails before any assertion if they do.
+	mux := newRouter(
+		cfg,
+		svc,
+		wsm,
+		pow.New([]byte("secret"), 1, time.Minute),
+		voter.New([]byte("secret")),
+		newVoteCoalescer(time.Second, wsm, logger),
+		newLimiter,
+		logger,
+		web,
+	)
+
+	tests := []struct {
+		method  string
+		path    string
+		pattern string
+	}{
+		{"GET", "/", "GET /"},
+		{"GET", "/ws", "GET /ws"},
+		{"GET", "/ws/schema.json", "GET /ws/schema.json"},
+		{"GET", "/abc", "GET /{id}"},
+		{"GET", "/panelists/abc/Ada", "GET /panelists/{id}/{panelist}"},
+		{"GET", "/abc/present", "GET /{id}/present"},
+		{"GET", "/abc/qr.png", "GET /{id}/qr.png"},
+		{"GET", "/abc/qr.svg", "GET /{id}/qr.svg"},
+		{"POST", "/questionnaires", "POST /questionnaires"},
+		{"POST", "/questionnaires/abc/questions", "POST /questionnaires/{id}/questions"},
+		{"PUT", "/questionnaires/abc/settings", "PUT /questionnaires/{id}/settings"},
+		{"PUT", "/questionnaires/abc/pin", "PUT /questionnaires/{id}/pin"},
+		{"PUT", "/questionnaires/abc/spotlight", "PUT /questionnaires/{id}/spotlight"},
+		{"GET", "/questionnaires/abc/challenge", "GET /questionnaires/{id}/challenge"},
+		{"GET", "/questionnaires/abc/questions", "GET /questionnaires/{id}/questions"},
+		{"GET", "/questionnaires/abc/questions/suggest", "GET /questionnaires/{id}/questions/suggest"},
+		{"GET", "/questionnaires/abc/questions/search", "GET /questionnaires/{id}/questions/search"},
+		{"PUT", "/questionnaires/abc/questions/q1/vote", "PUT /questionnaires/{id}/questions/{question_id}/vote"},
+		{"PUT", "/questionnaires/abc/questions/q1/answer", "PUT /questionnaires/{id}/questions/{question_id}/answer"},
+		{"PUT", "/questionnaires/abc/questions/q1/tag", "PUT /questionnaires/{id}/questions/{question_id}/tag"},
+		{"POST", "/questionnaires/abc/questions/q1/ban", "POST /questionnaires/{id}/questions/{question_id}/ban"},
+		{"POST", "/questionnaires/abc/questions/q1/merge", "POST /questionnaires/{id}/questions/{question_id}/merge"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			_, pattern := mux.Handler(req)
+			if pattern != tt.pattern {
+				t.Errorf("got pattern %q, want %q", pattern, tt.pattern)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/germandv/ama/internal/qr"
+	"github.com/germandv/ama/internal/questionnaire"
+	"github.com/germandv/ama/internal/webutils"
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	defaultQRSize = 256
+	minQRSize     = 64
+	maxQRSize     = 2048
+)
+
+// qrHandler renders a QR code pointing to the questionnaire page, as "png" or "svg".
+// The "size" query param sets the width in pixels and "ec" the error correction level.
+func qrHandler(svc questionnaire.IService, web webutils.Web, format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		questionnaireID := r.PathValue("id")
+		if questionnaireID == "" {
+			web.BadRequest(w, errors.New("no questionnaire ID provided"))
+			return
+		}
+
+		_, err := svc.GetMeta(questionnaireID)
+		if err != nil {
+			web.NotFound(w, "questionnaire", questionnaireID)
+			return
+		}
+
+		size := defaultQRSize
+		if s := r.URL.Query().Get("size"); s != "" {
+			size, err = strconv.Atoi(s)
+			if err != nil || size < minQRSize || size > maxQRSize {
+				web.BadRequest(w, fmt.Errorf("size must be between %d and %d", minQRSize, maxQRSize))
+				return
+			}
+		}
+
+		level := qrcode.Medium
+		if ec := r.URL.Query().Get("ec"); ec != "" {
+			level, err = qr.ParseLevel(ec)
+			if err != nil {
+				web.BadRequest(w, err)
+				return
+			}
+		}
+
+		joinURL := fmt.Sprintf("%s/%s", web.GetApiURL(), questionnaireID)
+
+		img, contentType := []byte(nil), "image/png"
+		if format == "svg" {
+			img, err = qr.SVG(joinURL, level, size)
+			contentType = "image/svg+xml"
+		} else {
+			img, err = qr.PNG(joinURL, level, size)
+		}
+		if err != nil {
+			web.InternalError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		// The code only depends on the URL, which does not change during the questionnaire's life.
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		w.Write(img)
+	}
+}
@@ -2,83 +2,44 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"time"
 
+	"github.com/germandv/ama/internal/protocol"
 	"github.com/germandv/ama/internal/questionnaire"
 	"github.com/germandv/ama/internal/webutils"
 	"github.com/germandv/ama/internal/wsmanager"
+	"github.com/gorilla/websocket"
 )
 
-type MessageEvent string
-
+// presenceDebounce is how long to wait for joins/leaves to settle before
+// broadcasting the number of connected clients. With people constantly coming
+// and going it never settles, so the count is broadcast at least every presenceMaxWait.
 const (
-	MessageEventNewQuestion = MessageEvent("new_question")
-	MessageEventVote        = MessageEvent("vote")
-	MessageAnswer           = MessageEvent("answer")
+	presenceDebounce = 500 * time.Millisecond
+	presenceMaxWait  = 2 * time.Second
 )
 
-type QuestionMessage struct {
-	Event   MessageEvent `json:"event"`
-	Details struct {
-		ID       string `json:"id"`
-		Question string `json:"question"`
-		Votes    uint16 `json:"votes"`
-	} `json:"details"`
-}
-
-func newQuestionMessage(id string, question string, votes uint16) QuestionMessage {
-	return QuestionMessage{
-		Event: MessageEventNewQuestion,
-		Details: struct {
-			ID       string `json:"id"`
-			Question string `json:"question"`
-			Votes    uint16 `json:"votes"`
-		}{
-			ID:       id,
-			Question: question,
-			Votes:    votes,
-		},
-	}
-}
-
-type VoteMessage struct {
-	Event   MessageEvent `json:"event"`
-	Details struct {
-		ID    string `json:"id"`
-		Votes uint16 `json:"votes"`
-	} `json:"details"`
-}
-
-func newVoteMessage(id string, votes uint16) VoteMessage {
-	return VoteMessage{
-		Event: MessageEventVote,
-		Details: struct {
-			ID    string `json:"id"`
-			Votes uint16 `json:"votes"`
-		}{
-			ID:    id,
-			Votes: votes,
-		},
-	}
-}
+// shutdownReason is sent to WS clients in the close frame when the server stops.
+const shutdownReason = "server restarting, reconnect"
 
-type AnswerMessage struct {
-	Event   MessageEvent `json:"event"`
-	Details struct {
-		ID string `json:"id"`
-	} `json:"details"`
-}
+// schedulePresence broadcasts the number of clients in the room once it stops changing.
+func schedulePresence(wsm *wsmanager.WSManager, room string, logger *slog.Logger) {
+	wsm.Debounce("presence:"+room, presenceDebounce, presenceMaxWait, func() {
+		count, err := wsm.CountClients(room)
+		if err != nil || count == 0 {
+			return
+		}
 
-func newAnswerMessage(id string) AnswerMessage {
-	return AnswerMessage{
-		Event: MessageAnswer,
-		Details: struct {
-			ID string `json:"id"`
-		}{
-			ID: id,
-		},
-	}
+		jsonMsg, err := protocol.Encode(protocol.NewPresence(count))
+		if err != nil {
+			logger.Error("error encoding presence message", "err", err)
+			return
+		}
+		wsm.Broadcast(room, jsonMsg)
+	})
 }
 
 func wsHandler(
@@ -94,14 +55,53 @@ func wsHandler(
 			return
 		}
 
-		c, err := wsm.Upgrader.Upgrade(w, r, nil)
+		if wsm.IsClosing() {
+			http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+
+		meta, err := svc.GetMeta(questionnaire)
 		if err != nil {
-			web.InternalError(w, errors.New("error upgrading connection"))
+			web.NotFound(w, "questionnaire", questionnaire)
 			return
 		}
 
-		wsm.AddClient(questionnaire, c)
+		header, err := protocol.Negotiate(r)
+		if err != nil {
+			web.BadRequest(w, err)
+			return
+		}
+
+		ip := clientIP(r)
+		if !wsm.AcquireIP(ip) {
+			setRetryAfter(w, capRetryAfter)
+			web.TooManyRequests(w, fmt.Sprintf("too many WS connections from %s", ip))
+			return
+		}
+		defer wsm.ReleaseIP(ip)
+
+		// Upgrade replies to the client itself when it fails.
+		c, err := wsm.Upgrader.Upgrade(w, r, header)
+		if err != nil {
+			logger.Info("error upgrading connection", "err", err)
+			return
+		}
+
+		isHost := false
+		cookie, err := r.Cookie("host")
+		if err == nil && cookie.Value == meta.Host {
+			isHost = true
+		}
+
+		err = wsm.AddClient(questionnaire, c, isHost)
+		if err != nil {
+			msg := websocket.FormatCloseMessage(websocket.CloseServiceRestart, shutdownReason)
+			c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			c.Close()
+			return
+		}
 		wsm.Stats()
+		schedulePresence(wsm, questionnaire, logger)
 
 		defer func() {
 			c.Close()
@@ -110,6 +110,8 @@ func wsHandler(
 			count, err := wsm.CountClients(questionnaire)
 			if err == nil && count == 0 {
 				wsm.DeleteRoom(questionnaire)
+			} else {
+				schedulePresence(wsm, questionnaire, logger)
 			}
 
 			wsm.Stats()
@@ -128,3 +130,11 @@ func wsHandler(
 		}
 	}
 }
+
+// wsSchemaHandler serves the JSON Schema of the messages sent over the WebSocket.
+func wsSchemaHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/schema+json")
+		w.Write(protocol.Schema)
+	}
+}